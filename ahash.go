@@ -0,0 +1,94 @@
+package blockhash
+
+import (
+	"context"
+	"image"
+
+	"github.com/dsoprea/go-logging"
+)
+
+const (
+	// DefaultAHashHashbits is the aHash grid size used when none is given.
+	DefaultAHashHashbits = 8
+)
+
+// AHash calculates average hashes (aHash). The image is reduced to a grid of
+// (columns x rows) blocks and each bit records whether a block is
+// brighter than the mean of all of the blocks. This is the simplest of the
+// hashes and works well for thumbnails and icons.
+type AHash struct {
+	columns int
+	rows    int
+	method  Method
+}
+
+// NewAHash returns an aHash hasher. The grid size defaults to
+// DefaultAHashHashbits.
+func NewAHash(opts ...Option) (ah *AHash, err error) {
+	o := newOptions(DefaultAHashHashbits, opts)
+
+	err = o.validate()
+	if err != nil {
+		return nil, err
+	}
+
+	ah = &AHash{
+		columns: o.columns,
+		rows:    o.rows,
+		method:  o.method,
+	}
+
+	return ah, nil
+}
+
+// Hash calculates the digest of the given image.
+func (ah *AHash) Hash(img image.Image) (digest Digest, err error) {
+	return ah.HashContext(context.Background(), img)
+}
+
+// HashContext calculates the digest of the given image. The context's error is
+// returned if it is canceled while the image is being read.
+func (ah *AHash) HashContext(ctx context.Context, img image.Image) (digest Digest, err error) {
+	defer func() {
+		if state := recover(); state != nil {
+			err = log.Wrap(state.(error))
+		}
+	}()
+
+	err = validateImage(img, ah.columns, ah.rows)
+	if err != nil {
+		return Digest{}, err
+	}
+
+	pr := newPixelReader(img)
+	bb := getBlockBuffers()
+	defer putBlockBuffers(bb)
+
+	blocks, err := getBlocksForMethod(ctx, pr, ah.method, ah.columns, ah.rows, bb)
+	if err != nil {
+		return Digest{}, err
+	}
+
+	bits := aHashBits(blocks)
+
+	return newDigest(AlgorithmAHash, bits, ah.columns, ah.rows), nil
+}
+
+// aHashBits compares every block against the mean of all of the blocks.
+func aHashBits(blocks []float64) []int {
+	total := 0.0
+	for _, v := range blocks {
+		total += v
+	}
+
+	mean := total / float64(len(blocks))
+
+	bits := make([]int, len(blocks))
+	for i, v := range blocks {
+		if v > mean {
+			bits[i] = 1
+		}
+	}
+
+	return bits
+}
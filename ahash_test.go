@@ -0,0 +1,99 @@
+package blockhash
+
+import (
+	"image"
+	"image/color"
+	"testing"
+
+	"github.com/dsoprea/go-logging"
+)
+
+func TestAHashBits(t *testing.T) {
+	// The mean is 25.
+	blocks := []float64{0, 10, 40, 50, 25, 26, 24, 25}
+
+	bits := aHashBits(blocks)
+
+	expected := []int{0, 0, 1, 1, 0, 1, 0, 0}
+	for i, bit := range expected {
+		if bits[i] != bit {
+			t.Fatalf("bit (%d) not correct: %v", i, bits)
+		}
+	}
+}
+
+func TestNewAHash(t *testing.T) {
+	ah, err := NewAHash()
+	log.PanicIf(err)
+
+	if ah.columns != DefaultAHashHashbits || ah.rows != DefaultAHashHashbits {
+		t.Fatalf("default hashbits not applied: (%d) (%d)", ah.columns, ah.rows)
+	}
+
+	_, err = NewAHash(WithHashbits(0))
+	if err != ErrInvalidHashbits {
+		t.Fatalf("expected invalid-hashbits error: %v", err)
+	}
+}
+
+func TestAHash_Hash(t *testing.T) {
+	// The left half of the image is white and the right half is black, so
+	// every row of the grid reads 11110000.
+
+	i := image.NewGray(image.Rect(0, 0, 80, 40))
+	for y := 0; y < 40; y++ {
+		for x := 0; x < 40; x++ {
+			i.SetGray(x, y, color.Gray{Y: 255})
+		}
+	}
+
+	for _, method := range []Method{MethodPrecise, MethodQuick} {
+		ah, err := NewAHash(WithMethod(method))
+		log.PanicIf(err)
+
+		digest, err := ah.Hash(i)
+		log.PanicIf(err)
+
+		if digest.String() != "f0f0f0f0f0f0f0f0" {
+			t.Fatalf("digest not correct: [%s]", digest)
+		}
+	}
+}
+
+func TestAHash_Hash__Similar(t *testing.T) {
+	f, i1 := getTestImage(testImagePng1Small)
+	defer f.Close()
+
+	f2, i2 := getTestImage(testImagePng1SmallEven)
+	defer f2.Close()
+
+	ah, err := NewAHash()
+	log.PanicIf(err)
+
+	d1, err := ah.Hash(i1)
+	log.PanicIf(err)
+
+	d2, err := ah.Hash(i2)
+	log.PanicIf(err)
+
+	distance, err := d1.Distance(d2)
+	log.PanicIf(err)
+
+	if distance > 4 {
+		t.Fatalf("distance between similar images too large: (%d)", distance)
+	}
+
+	if len(d1.String()) != 16 {
+		t.Fatalf("digest not in the same format as blockhash: [%s]", d1)
+	}
+}
+
+func TestAHash_Hash__ImageTooSmall(t *testing.T) {
+	ah, err := NewAHash(WithHashbits(16))
+	log.PanicIf(err)
+
+	_, err = ah.Hash(image.NewGray(image.Rect(0, 0, 15, 15)))
+	if err != ErrImageTooSmall {
+		t.Fatalf("expected image-too-small error: %v", err)
+	}
+}
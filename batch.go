@@ -0,0 +1,202 @@
+package blockhash
+
+import (
+	"context"
+	"image"
+	"io"
+	"os"
+	"runtime"
+	"sync"
+
+	"github.com/dsoprea/go-logging"
+)
+
+// BatchItem is one image to be hashed by HashBatch().
+type BatchItem struct {
+	// ID identifies the item in the results. It is not interpreted.
+	ID string
+
+	// Open returns a reader for the encoded image. It is closed once the image
+	// has been decoded. The formats that can be decoded are the ones that the
+	// application has registered (e.g. by importing "image/png").
+	Open func() (io.ReadCloser, error)
+}
+
+// NewFileBatchItem returns a BatchItem that reads the given file and is
+// identified by its path.
+func NewFileBatchItem(filepath string) BatchItem {
+	return BatchItem{
+		ID: filepath,
+		Open: func() (io.ReadCloser, error) {
+			return os.Open(filepath)
+		},
+	}
+}
+
+// BatchResult is the outcome of hashing one BatchItem.
+type BatchResult struct {
+	// Item is the item that was hashed.
+	Item BatchItem
+
+	// Index is the position of the item in the input, starting at zero.
+	Index int
+
+	// Digest is the digest of the image if `Err` is nil.
+	Digest Digest
+
+	// Err is the error that occurred while opening, decoding, or hashing the
+	// image, if any.
+	Err error
+}
+
+type indexedBatchItem struct {
+	item  BatchItem
+	index int
+}
+
+// HashBatch decodes and hashes the items received from `items` using
+// `workers` goroutines (the number of CPUs if not positive). A result is sent
+// for every item, in the order that they finish, with any error for that item.
+// The returned channel is closed after `items` is closed and every item has
+// been processed, or once the context is canceled. Items that were not
+// started before cancellation produce no result.
+func HashBatch(ctx context.Context, hasher Hasher, items <-chan BatchItem, workers int) <-chan BatchResult {
+	if workers <= 0 {
+		workers = runtime.NumCPU()
+	}
+
+	results := make(chan BatchResult, workers)
+
+	// Number the items as they arrive so that results can be matched up with
+	// the input.
+
+	indexed := make(chan indexedBatchItem)
+
+	go func() {
+		defer close(indexed)
+
+		index := 0
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case item, ok := <-items:
+				if ok == false {
+					return
+				}
+
+				select {
+				case <-ctx.Done():
+					return
+				case indexed <- indexedBatchItem{item: item, index: index}:
+				}
+
+				index++
+			}
+		}
+	}()
+
+	wg := new(sync.WaitGroup)
+
+	for i := 0; i < workers; i++ {
+		wg.Add(1)
+
+		go func() {
+			defer wg.Done()
+
+			for ii := range indexed {
+				// Don't start anything new once we've been canceled.
+				if ctx.Err() != nil {
+					continue
+				}
+
+				digest, err := hashBatchItem(ctx, hasher, ii.item)
+
+				result := BatchResult{
+					Item:   ii.item,
+					Index:  ii.index,
+					Digest: digest,
+					Err:    err,
+				}
+
+				select {
+				case <-ctx.Done():
+				case results <- result:
+				}
+			}
+		}()
+	}
+
+	go func() {
+		wg.Wait()
+		close(results)
+	}()
+
+	return results
+}
+
+// HashBatchItems hashes all of the given items with HashBatch() and returns
+// the results in the same order as the items. If the context is canceled, the
+// results gathered so far are returned, in the order that they finished, along
+// with the context's error.
+func HashBatchItems(ctx context.Context, hasher Hasher, items []BatchItem, workers int) (results []BatchResult, err error) {
+	itemsC := make(chan BatchItem)
+
+	go func() {
+		defer close(itemsC)
+
+		for _, item := range items {
+			select {
+			case <-ctx.Done():
+				return
+			case itemsC <- item:
+			}
+		}
+	}()
+
+	results = make([]BatchResult, 0, len(items))
+	for result := range HashBatch(ctx, hasher, itemsC, workers) {
+		results = append(results, result)
+	}
+
+	if err := ctx.Err(); err != nil {
+		return results, err
+	}
+
+	ordered := make([]BatchResult, len(results))
+	for _, result := range results {
+		ordered[result.Index] = result
+	}
+
+	return ordered, nil
+}
+
+func hashBatchItem(ctx context.Context, hasher Hasher, item BatchItem) (digest Digest, err error) {
+	defer func() {
+		if state := recover(); state != nil {
+			err = log.Wrap(state.(error))
+		}
+	}()
+
+	rc, err := item.Open()
+	log.PanicIf(err)
+
+	defer rc.Close()
+
+	img, _, err := image.Decode(rc)
+	log.PanicIf(err)
+
+	// Stop partway through the image if we're canceled and the hasher allows
+	// it.
+	if ch, ok := hasher.(ContextHasher); ok == true {
+		digest, err = ch.HashContext(ctx, img)
+	} else {
+		digest, err = hasher.Hash(img)
+	}
+
+	if err != nil {
+		return Digest{}, err
+	}
+
+	return digest, nil
+}
@@ -0,0 +1,155 @@
+package blockhash
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"io/ioutil"
+	"path"
+	"testing"
+
+	"github.com/dsoprea/go-logging"
+)
+
+func getTestBatchItems() []BatchItem {
+	return []BatchItem{
+		NewFileBatchItem(path.Join(assetsPath, testImagePng1Small)),
+		NewFileBatchItem(path.Join(assetsPath, testImagePng1SmallAlpha)),
+		NewFileBatchItem(path.Join(assetsPath, testImagePng1SmallEven)),
+	}
+}
+
+func TestHashBatch(t *testing.T) {
+	hasher, err := NewBlockhashHasher()
+	log.PanicIf(err)
+
+	items := getTestBatchItems()
+
+	itemsC := make(chan BatchItem)
+	go func() {
+		for _, item := range items {
+			itemsC <- item
+		}
+
+		close(itemsC)
+	}()
+
+	seen := make(map[int]bool)
+	for result := range HashBatch(context.Background(), hasher, itemsC, 2) {
+		log.PanicIf(result.Err)
+
+		if result.Item.ID != items[result.Index].ID {
+			t.Fatalf("result not matched to its item: [%s] (%d)", result.Item.ID, result.Index)
+		}
+
+		f, bh := getTestBh(path.Base(result.Item.ID))
+		f.Close()
+
+		if result.Digest.String() != bh.Hexdigest() {
+			t.Fatalf("digest not correct for [%s]: [%s]", result.Item.ID, result.Digest)
+		}
+
+		seen[result.Index] = true
+	}
+
+	if len(seen) != len(items) {
+		t.Fatalf("not every item had a result: (%d)", len(seen))
+	}
+}
+
+func TestHashBatchItems(t *testing.T) {
+	hasher, err := NewDHash()
+	log.PanicIf(err)
+
+	openError := errors.New("open failed")
+
+	items := getTestBatchItems()
+	items = append(items,
+		BatchItem{
+			ID: "missing",
+			Open: func() (io.ReadCloser, error) {
+				return nil, openError
+			},
+		},
+		BatchItem{
+			ID: "not-an-image",
+			Open: func() (io.ReadCloser, error) {
+				return ioutil.NopCloser(bytes.NewBufferString("not an image")), nil
+			},
+		})
+
+	results, err := HashBatchItems(context.Background(), hasher, items, 3)
+	log.PanicIf(err)
+
+	if len(results) != len(items) {
+		t.Fatalf("result count not correct: (%d)", len(results))
+	}
+
+	for i, result := range results[:3] {
+		if result.Item.ID != items[i].ID || result.Index != i {
+			t.Fatalf("results not in input order: [%s] (%d)", result.Item.ID, result.Index)
+		}
+
+		log.PanicIf(result.Err)
+
+		f, img := getTestImage(path.Base(result.Item.ID))
+		f.Close()
+
+		expected, err := hasher.Hash(img)
+		log.PanicIf(err)
+
+		if result.Digest.Equal(expected) != true {
+			t.Fatalf("digest not correct for [%s]", result.Item.ID)
+		}
+	}
+
+	if log.Is(results[3].Err, openError) != true {
+		t.Fatalf("expected open error: %v", results[3].Err)
+	} else if results[4].Err == nil {
+		t.Fatalf("expected decode error")
+	}
+}
+
+func TestHashBatchItems__Canceled(t *testing.T) {
+	hasher, err := NewBlockhashHasher()
+	log.PanicIf(err)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	items := make([]BatchItem, 0)
+	for i := 0; i < 100; i++ {
+		items = append(items, getTestBatchItems()...)
+	}
+
+	results, err := HashBatchItems(ctx, hasher, items, 4)
+	if err != context.Canceled {
+		t.Fatalf("expected cancellation error: %v", err)
+	} else if len(results) == len(items) {
+		t.Fatalf("expected cancellation to skip items")
+	}
+}
+
+func TestHashBatch__CanceledWhileRunning(t *testing.T) {
+	hasher, err := NewBlockhashHasher()
+	log.PanicIf(err)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	// Never closed; only cancellation ends the batch.
+	itemsC := make(chan BatchItem)
+
+	results := HashBatch(ctx, hasher, itemsC, 2)
+
+	itemsC <- getTestBatchItems()[0]
+
+	result := <-results
+	log.PanicIf(result.Err)
+
+	cancel()
+
+	for range results {
+	}
+}
@@ -1,130 +1,100 @@
 package blockhash
 
 import (
-	"fmt"
+	"context"
+	"errors"
 	"image"
 	"image/color"
 	"math"
-	"math/big"
-	"sort"
-	"strconv"
+	"sync"
 
 	"github.com/dsoprea/go-logging"
 )
 
-type Blockhash struct {
-	image        image.Image
-	hashbits     int
-	toColor      *color.Model
-	hasAlpha     bool
-	hexdigest    string
-	isOpaqueable bool
-}
+var (
+	// ErrInvalidHashbits indicates that the number of columns or rows in the
+	// grid is not positive.
+	ErrInvalidHashbits = errors.New("hashbits must be positive")
 
-// opaqueableModel automatically fulfilled by existing Go types.
-type opaqueableModel interface {
-	Opaque() bool
-}
+	// ErrInvalidMethod indicates that the block aggregation method is not
+	// known.
+	ErrInvalidMethod = errors.New("invalid method")
 
-func NewBlockhash(image image.Image, hashbits int) *Blockhash {
-	// Only images that support alpha are explicitly aware of opaqueness.
-	_, isOpaqueable := image.(opaqueableModel)
+	// ErrEmptyImage indicates that the image has no pixels.
+	ErrEmptyImage = errors.New("image is empty")
 
-	// If the bits aren't aligned, the digest won't make sense as a hex string.
-	if (hashbits % 4) != 0 {
-		log.Panicf("Bits must be a multiple of four: (%d)", hashbits)
-	}
+	// ErrImageTooSmall indicates that the image has fewer pixels than the grid
+	// in at least one dimension.
+	ErrImageTooSmall = errors.New("image is smaller than the hash grid")
+)
 
-	return &Blockhash{
-		image:        image,
-		hashbits:     hashbits,
-		isOpaqueable: isOpaqueable,
-	}
+// Blockhash calculates hashes with the blockhash.io algorithm. It can either
+// be bound to one image (New(), NewBlockhash()) or used as a Hasher for any
+// number of images (NewBlockhashHasher()). It is safe to use concurrently.
+type Blockhash struct {
+	image    image.Image
+	columns  int
+	rows     int
+	method   Method
+	toColor  *color.Model
+	hasAlpha bool
+	digest   *Digest
+	reader   *pixelReader
+
+	// digestLock protects the digest of the bound image, which is calculated
+	// on first use.
+	digestLock sync.Mutex
 }
 
-func (bh *Blockhash) totalValue(p color.Color) (value uint32) {
-	defer func() {
-		if state := recover(); state != nil {
-			log.Panic(state.(error))
-		}
-	}()
+// NewBlockhashHasher returns a blockhash Hasher that isn't bound to any
+// image.
+func NewBlockhashHasher(opts ...Option) (bh *Blockhash, err error) {
+	o := newOptions(DefaultHashbits, opts)
 
-	// The RGBA() will return the alpha-multiplied values but the fields will
-	// still be in their premultiplied state.
-	if bh.image.ColorModel() != color.RGBAModel {
-		p = color.RGBAModel.Convert(p)
+	err = o.validate()
+	if err != nil {
+		return nil, err
 	}
 
-	c2 := p.(color.RGBA)
-
-	if bh.isOpaqueable == true && c2.A == 0 {
-		return 765
+	bh = &Blockhash{
+		columns: o.columns,
+		rows:    o.rows,
+		method:  o.method,
 	}
 
-	return uint32(c2.R) + uint32(c2.G) + uint32(c2.B)
-}
-
-func (bh *Blockhash) totalValueAt(x, y int) (value uint32) {
-	defer func() {
-		if state := recover(); state != nil {
-			log.Panic(state.(error))
-		}
-	}()
-
-	p := bh.image.At(x, y)
-
-	return bh.totalValue(p)
+	return bh, nil
 }
 
-func (bh *Blockhash) median(data []float64) float64 {
-	defer func() {
-		if state := recover(); state != nil {
-			log.Panic(state.(error))
-		}
-	}()
-
-	copied := make([]float64, len(data))
-	copy(copied, data)
-	sort.Float64s(copied)
-
-	len_ := len(copied)
-	if len(copied)%2 == 0 {
-		v := (copied[len_/2-1] + copied[len_/2]) / 2.0
-
-		return v
-	} else {
-		v := copied[len_/2]
-
-		return v
+// New returns a hash for the given image. The options are validated against
+// the image and a typed error is returned if they can not be satisfied.
+func New(img image.Image, opts ...Option) (bh *Blockhash, err error) {
+	bh, err = NewBlockhashHasher(opts...)
+	if err != nil {
+		return nil, err
 	}
-}
 
-func (bh *Blockhash) bitsToHex(bitString []int) string {
-	defer func() {
-		if state := recover(); state != nil {
-			log.Panic(state.(error))
-		}
-	}()
+	err = validateImage(img, bh.columns, bh.rows)
+	if err != nil {
+		return nil, err
+	}
 
-	s := make([]byte, len(bitString))
+	bh.image = img
+	bh.reader = newPixelReader(img)
 
-	for i, d := range bitString {
-		if d == 0 {
-			s[i] = '0'
-		} else if d == 1 {
-			s[i] = '1'
-		} else {
-			log.Panicf("invalid bit value (%d) at offset (%d)", d, i)
-		}
-	}
+	return bh, nil
+}
 
-	b := new(big.Int)
-	b.SetString(string(s), 2)
+// NewBlockhash is the same as New() except that it panics if the image or
+// `hashbits` are not valid.
+func NewBlockhash(image image.Image, hashbits int) *Blockhash {
+	bh, err := New(image, WithHashbits(hashbits))
+	log.PanicIf(err)
 
-	width := int(math.Pow(float64(bh.hashbits), 2.0) / 4.0)
-	encoded := fmt.Sprintf("%0"+strconv.Itoa(width)+"x", b)
+	return bh
+}
 
-	return encoded
+func (bh *Blockhash) median(data []float64) float64 {
+	return median(data)
 }
 
 func (bh *Blockhash) translateBlocksToBits(blocksInline []float64, pixelsPerBlock float64) (results []int) {
@@ -137,12 +107,22 @@ func (bh *Blockhash) translateBlocksToBits(blocksInline []float64, pixelsPerBloc
 	blocks := make([]int, len(blocksInline))
 	halfBlockValue := pixelsPerBlock * 256.0 * 3.0 / 2.0
 
-	bandsize := int(math.Floor(float64(len(blocksInline)) / 4.0))
+	// The blocks are split into four horizontal bands, each compared to its
+	// own median. If the blocks don't divide evenly, the bands differ in size
+	// by at most one block rather than leaving any blocks out.
 
 	for i := 0; i < 4; i++ {
-		m := bh.median(blocksInline[i*bandsize : (i+1)*bandsize])
+		bandStart := i * len(blocksInline) / 4
+		bandEnd := (i + 1) * len(blocksInline) / 4
+
+		// Tiny grids have fewer blocks than bands.
+		if bandStart == bandEnd {
+			continue
+		}
+
+		m := bh.median(blocksInline[bandStart:bandEnd])
 
-		for j := i * bandsize; j < (i+1)*bandsize; j++ {
+		for j := bandStart; j < bandEnd; j++ {
 			v := blocksInline[j]
 
 			// TODO(dustin): Use epsilon.
@@ -158,125 +138,82 @@ func (bh *Blockhash) translateBlocksToBits(blocksInline []float64, pixelsPerBloc
 }
 
 func (bh *Blockhash) size() (width int, height int) {
-	r := bh.image.Bounds()
-
-	width = r.Max.X
-	height = r.Max.Y
-
-	return width, height
+	return bh.reader.size()
 }
 
-func (bh *Blockhash) getBlocks() []float64 {
-	width, height := bh.size()
-
-	isEvenX := (width % bh.hashbits) == 0
-	isEvenY := (height % bh.hashbits) == 0
+func (bh *Blockhash) process(ctx context.Context) (err error) {
+	defer func() {
+		if state := recover(); state != nil {
+			err = log.Wrap(state.(error))
+		}
+	}()
 
-	blocks := make([][]float64, bh.hashbits)
+	bh.digestLock.Lock()
+	defer bh.digestLock.Unlock()
 
-	for i := 0; i < bh.hashbits; i++ {
-		blocks[i] = make([]float64, bh.hashbits)
+	if bh.digest != nil {
+		return nil
 	}
 
-	blockWidth := float64(width) / float64(bh.hashbits)
-	blockHeight := float64(height) / float64(bh.hashbits)
-
-	for y := 0; y < height; y++ {
-		var weightTop, weightBottom, weightLeft, weightRight float64
-		var blockTop, blockBottom, blockLeft, blockRight int
-
-		if isEvenY {
-			blockTop = int(math.Floor(float64(y) / blockHeight))
-			blockBottom = blockTop
-
-			weightTop = 1.0
-			weightBottom = 0.0
-		} else {
-			yMod := math.Mod((float64(y) + 1.0), blockHeight)
-			yInt, yFrac := math.Modf(yMod)
+	// We were constructed as a Hasher and never bound to an image.
+	if bh.reader == nil {
+		return ErrEmptyImage
+	}
 
-			weightTop = (1.0 - yFrac)
-			weightBottom = yFrac
+	// If we're canceled, nothing is stored and the next call starts over.
+	digest, err := bh.hashPixels(ctx, bh.reader)
+	if err != nil {
+		return err
+	}
 
-			// y_int will be 0 on bottom/right borders and on block boundaries
-			if yInt > 0.0 || (y+1) == height {
-				blockTop = int(math.Floor(float64(y) / blockHeight))
-				blockBottom = blockTop
-			} else {
-				blockTop = int(math.Floor(float64(y) / blockHeight))
-				blockBottom = int(math.Ceil(float64(y) / blockHeight))
-			}
+	bh.digest = &digest
 
-		}
+	return nil
+}
 
-		for x := 0; x < width; x++ {
-			value := bh.totalValueAt(x, y)
+func (bh *Blockhash) hashPixels(ctx context.Context, pr *pixelReader) (digest Digest, err error) {
+	bb := getBlockBuffers()
+	defer putBlockBuffers(bb)
 
-			if isEvenX {
-				blockRight = int(math.Floor(float64(x) / blockWidth))
-				blockLeft = blockRight
+	blocks, err := getBlocksForMethod(ctx, pr, bh.method, bh.columns, bh.rows, bb)
+	if err != nil {
+		return Digest{}, err
+	}
 
-				weightLeft = 1.0
-				weightRight = 0.0
-			} else {
-				xMod := math.Mod((float64(x) + 1.0), blockWidth)
-				xInt, xFrac := math.Modf(xMod)
-
-				weightLeft = (1.0 - xFrac)
-				weightRight = (xFrac)
-
-				if xInt > 0.0 || (x+1) == width {
-					blockRight = int(math.Floor(float64(x) / blockWidth))
-					blockLeft = blockRight
-				} else {
-					blockLeft = int(math.Floor(float64(x) / blockWidth))
-					blockRight = int(math.Ceil(float64(x) / blockWidth))
-				}
-			}
+	pixelsPerBlock := getPixelsPerBlock(pr, bh.method, bh.columns, bh.rows)
 
-			blocks[blockTop][blockLeft] += float64(value) * weightTop * weightLeft
-			blocks[blockTop][blockRight] += float64(value) * weightTop * weightRight
-			blocks[blockBottom][blockLeft] += float64(value) * weightBottom * weightLeft
-			blocks[blockBottom][blockRight] += float64(value) * weightBottom * weightRight
-		}
-	}
+	bits := bh.translateBlocksToBits(blocks, pixelsPerBlock)
 
-	blocksInline := make([]float64, bh.hashbits*bh.hashbits)
-	i := 0
-	for y := 0; y < bh.hashbits; y++ {
-		for x := 0; x < bh.hashbits; x++ {
-			blocksInline[i] = blocks[y][x]
-			i++
-		}
-	}
+	return newDigest(AlgorithmBlockhash, bits, bh.columns, bh.rows), nil
+}
 
-	return blocksInline
+// Hash calculates the digest of the given image using the same options. The
+// image that this hash was bound to, if any, is not affected.
+func (bh *Blockhash) Hash(img image.Image) (digest Digest, err error) {
+	return bh.HashContext(context.Background(), img)
 }
 
-func (bh *Blockhash) process() (err error) {
+// HashContext is the same as Hash() except that the context's error is
+// returned if it is canceled while the image is being read.
+func (bh *Blockhash) HashContext(ctx context.Context, img image.Image) (digest Digest, err error) {
 	defer func() {
 		if state := recover(); state != nil {
 			err = log.Wrap(state.(error))
 		}
 	}()
 
-	if bh.hexdigest != "" {
-		return nil
+	err = validateImage(img, bh.columns, bh.rows)
+	if err != nil {
+		return Digest{}, err
 	}
 
-	blocks := bh.getBlocks()
+	pr := newPixelReader(img)
 
-	width, height := bh.size()
-
-	blockWidth := float64(width) / float64(bh.hashbits)
-	blockHeight := float64(height) / float64(bh.hashbits)
-
-	digest := bh.translateBlocksToBits(blocks, blockWidth*blockHeight)
-	bh.hexdigest = bh.bitsToHex(digest)
-
-	return nil
+	return bh.hashPixels(ctx, pr)
 }
 
+// Hexdigest returns the computed digest as a hex string. It panics if the hash
+// can not be calculated; use Digest() to get an error instead.
 func (bh *Blockhash) Hexdigest() string {
 	defer func() {
 		if state := recover(); state != nil {
@@ -285,8 +222,28 @@ func (bh *Blockhash) Hexdigest() string {
 		}
 	}()
 
-	err := bh.process()
+	digest, err := bh.Digest()
 	log.PanicIf(err)
 
-	return bh.hexdigest
+	return digest.String()
+}
+
+// Digest returns the computed digest.
+func (bh *Blockhash) Digest() (digest Digest, err error) {
+	return bh.DigestContext(context.Background())
+}
+
+// DigestContext is the same as Digest() except that the context's error is
+// returned if it is canceled while the image is being read. The digest is only
+// kept if it was completely calculated.
+func (bh *Blockhash) DigestContext(ctx context.Context) (digest Digest, err error) {
+	err = bh.process(ctx)
+	if err != nil {
+		return Digest{}, err
+	}
+
+	bh.digestLock.Lock()
+	defer bh.digestLock.Unlock()
+
+	return *bh.digest, nil
 }
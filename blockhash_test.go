@@ -1,6 +1,7 @@
 package blockhash
 
 import (
+	"context"
 	"image"
 	"image/color"
 	"math"
@@ -19,7 +20,6 @@ import (
 
 const (
 	testImageJpeg1Big         = "20170618_155330.jpg"
-	testImagePng1Big          = "20170618_155330.png"
 	testImagePng1BigGrayscale = "20170618_155330-grayscale.png"
 	testImagePng1Small        = "20170618_155330-small.png"
 	testImagePng1SmallAlpha   = "20170618_155330-small-alpha.png"
@@ -55,84 +55,9 @@ func getTestBh(filename string) (f *os.File, bh *Blockhash) {
 	return f, bh
 }
 
-func TestBitsToHex__11010010(t *testing.T) {
-	f, bh := getTestBh(testImageJpeg1Big)
-	defer f.Close()
-
-	actual := bh.bitsToHex([]int{1, 1, 0, 1, 0, 0, 1, 0})
-	expected := "00000000000000000000000000000000000000000000000000000000000000d2"
-
-	if actual != expected {
-		t.Fatalf("digest not correct: [%s] != [%s]", actual, expected)
-	}
-}
-
-func TestBitsToHex__1(t *testing.T) {
-	f, bh := getTestBh(testImageJpeg1Big)
-	defer f.Close()
-
-	actual := bh.bitsToHex([]int{1})
-	expected := "0000000000000000000000000000000000000000000000000000000000000001"
-
-	if actual != expected {
-		t.Fatalf("digest not correct: [%s] != [%s]", actual, expected)
-	}
-}
-
-func TestBitsToHex__101(t *testing.T) {
-	f, bh := getTestBh(testImageJpeg1Big)
-	defer f.Close()
-
-	actual := bh.bitsToHex([]int{1, 0, 1})
-	expected := "0000000000000000000000000000000000000000000000000000000000000005"
-
-	if actual != expected {
-		t.Fatalf("digest not correct: [%s] != [%s]", actual, expected)
-	}
-}
-
-func TestBitsToHex__10101(t *testing.T) {
-	f, bh := getTestBh(testImageJpeg1Big)
-	defer f.Close()
-
-	actual := bh.bitsToHex([]int{1, 0, 1, 0, 1})
-	expected := "0000000000000000000000000000000000000000000000000000000000000015"
-
-	if actual != expected {
-		t.Fatalf("digest not correct: [%s] != [%s]", actual, expected)
-	}
-}
-
-// TestBitsToHex_10111111111111111 One bit larger than the official bit
-// width.
-func TestBitsToHex__10111111111111111(t *testing.T) {
-	f, bh := getTestBh(testImageJpeg1Big)
-	defer f.Close()
-
-	actual := bh.bitsToHex([]int{1, 0, 1, 1, 1, 1, 1, 1, 1, 1, 1, 1, 1, 1, 1, 1, 1})
-	expected := "0000000000000000000000000000000000000000000000000000000000017fff"
-
-	if actual != expected {
-		t.Fatalf("digest not correct: [%s] != [%s]", actual, expected)
-	}
-}
-
-// TestBitsToHex_1111111111111111 Exactly the right size..
-func TestBitsToHex__1101111111011111(t *testing.T) {
-	f, bh := getTestBh(testImageJpeg1Big)
-	defer f.Close()
-
-	actual := bh.bitsToHex([]int{1, 1, 0, 1, 1, 1, 1, 1, 1, 1, 0, 1, 1, 1, 1, 1})
-	expected := "000000000000000000000000000000000000000000000000000000000000dfdf"
-
-	if actual != expected {
-		t.Fatalf("digest not correct: [%s] != [%s]", actual, expected)
-	}
-}
-
 // TesttotalValueAtNonAlphaAndOddSize interprets an image with no alpha and odd
 // dimensions correctly.
-func TesttotalValueAt__NonAlphaAndOddSize(t *testing.T) {
+func TestTotalValueAt__NonAlphaAndOddSize(t *testing.T) {
 	f, bh := getTestBh(testImagePng1Small)
 	defer f.Close()
 
@@ -142,7 +67,7 @@ func TesttotalValueAt__NonAlphaAndOddSize(t *testing.T) {
 
 	for y := 0; y < maxY; y++ {
 		for x := 0; x < maxX; x++ {
-			colors[y*maxX+x] = bh.totalValueAt(x, y)
+			colors[y*maxX+x] = bh.reader.totalValueAt(x, y)
 		}
 	}
 
@@ -157,7 +82,7 @@ func TesttotalValueAt__NonAlphaAndOddSize(t *testing.T) {
 
 // TesttotalValueAtAlphaAndOddSize interprets an image with alpha and odd
 // dimensions correctly.
-func TesttotalValueAt__AlphaAndOddSize(t *testing.T) {
+func TestTotalValueAt__AlphaAndOddSize(t *testing.T) {
 	f, bh := getTestBh(testImagePng1SmallAlpha)
 	defer f.Close()
 
@@ -167,7 +92,7 @@ func TesttotalValueAt__AlphaAndOddSize(t *testing.T) {
 
 	for y := 0; y < maxY; y++ {
 		for x := 0; x < maxX; x++ {
-			colors[y*maxX+x] = bh.totalValueAt(x, y)
+			colors[y*maxX+x] = bh.reader.totalValueAt(x, y)
 		}
 	}
 
@@ -182,7 +107,7 @@ func TesttotalValueAt__AlphaAndOddSize(t *testing.T) {
 
 // TesttotalValueAtNonAlphaAndEvenSize interprets an image with no alpha and even
 // dimensions correctly.
-func TesttotalValueAt__NonAlphaAndEvenSize(t *testing.T) {
+func TestTotalValueAt__NonAlphaAndEvenSize(t *testing.T) {
 	f, bh := getTestBh(testImagePng1SmallEven)
 	defer f.Close()
 
@@ -192,7 +117,7 @@ func TesttotalValueAt__NonAlphaAndEvenSize(t *testing.T) {
 
 	for y := 0; y < maxY; y++ {
 		for x := 0; x < maxX; x++ {
-			colors[y*maxX+x] = bh.totalValueAt(x, y)
+			colors[y*maxX+x] = bh.reader.totalValueAt(x, y)
 		}
 	}
 
@@ -272,63 +197,15 @@ func TestMedian__UnsortedEven(t *testing.T) {
 	}
 }
 
-func TestBitsToHex__32(t *testing.T) {
-	f, bh := getTestBh(testImagePng1Small)
-	defer f.Close()
-
-	actual := bh.bitsToHex([]int{0, 0, 0, 1, 0, 0, 1, 0, 0, 1, 0, 0, 1, 0, 0, 0, 1, 0, 1, 0, 1, 0, 1, 1, 1, 1, 0, 0, 1, 1, 0, 1})
-
-	expected := "000000000000000000000000000000000000000000000000000000001248abcd"
-	if actual != expected {
-		t.Fatalf("bits not converted to hex properly: [%s] != [%s]", actual, expected)
-	}
-}
-
-func TestBitsToHex__16(t *testing.T) {
-	f, bh := getTestBh(testImagePng1Small)
-	defer f.Close()
-
-	actual := bh.bitsToHex([]int{1, 0, 1, 0, 1, 0, 1, 1, 1, 1, 0, 0, 1, 1, 0, 1})
-
-	expected := "000000000000000000000000000000000000000000000000000000000000abcd"
-	if actual != expected {
-		t.Fatalf("bits not converted to hex properly: [%s] != [%s]", actual, expected)
-	}
-}
-
-func TestBitsToHex__8(t *testing.T) {
-	f, bh := getTestBh(testImagePng1Small)
-	defer f.Close()
-
-	actual := bh.bitsToHex([]int{1, 1, 0, 0, 1, 1, 0, 1})
-
-	expected := "00000000000000000000000000000000000000000000000000000000000000cd"
-	if actual != expected {
-		t.Fatalf("bits not converted to hex properly: [%s] != [%s]", actual, expected)
-	}
-}
-
-func TestBitsToHex__4(t *testing.T) {
-	f, bh := getTestBh(testImagePng1Small)
-	defer f.Close()
-
-	actual := bh.bitsToHex([]int{1, 1, 0, 1})
-
-	expected := "000000000000000000000000000000000000000000000000000000000000000d"
-	if actual != expected {
-		t.Fatalf("bits not converted to hex properly: [%s] != [%s]", actual, expected)
-	}
-}
-
 func TestProcess(t *testing.T) {
 	f, bh := getTestBh(testImagePng1Small)
 	defer f.Close()
 
-	err := bh.process()
+	err := bh.process(context.Background())
 	log.PanicIf(err)
 
 	expected := "1ffc3fff00fe000031ff3e3f0f8007c03fff1f8d0f9806003ffc3ff80f0400f0"
-	if bh.hexdigest != expected {
+	if bh.digest.String() != expected {
 		t.Fatalf("digest not correct")
 	}
 }
@@ -395,7 +272,7 @@ func TestHash__Size_64(t *testing.T) {
 }
 
 func TestHash__Equivalence__Resize(t *testing.T) {
-	f, i := getTestImage(testImagePng1Big)
+	f, i := getTestImage(testImageJpeg1Big)
 	defer f.Close()
 
 	bh := NewBlockhash(i, 16)
@@ -474,7 +351,7 @@ func TestTotalValue__WithAlpha(t *testing.T) {
 	i.Set(0, 0, color.RGBA{R: 1, G: 2, B: 4, A: 1})
 
 	p := i.At(0, 0)
-	v := bh.totalValue(p)
+	v := bh.reader.totalValue(p)
 
 	if v != 7 {
 		t.Fatalf("calculated color value 1 not correct: [%v]", v)
@@ -486,9 +363,385 @@ func TestTotalValue__WithAlpha(t *testing.T) {
 	i.Set(0, 0, color.RGBA{R: 0, G: 0, B: 0, A: 0})
 
 	p = i.At(0, 0)
-	v = bh.totalValue(p)
+	v = bh.reader.totalValue(p)
 
 	if v != 765 {
 		t.Fatalf("calculated color value 2 not correct: [%v]", v)
 	}
 }
+
+func TestNew(t *testing.T) {
+	f, i := getTestImage(testImagePng1Small)
+	defer f.Close()
+
+	bh, err := New(i)
+	log.PanicIf(err)
+
+	if bh.columns != DefaultHashbits || bh.rows != DefaultHashbits {
+		t.Fatalf("default hashbits not applied: (%d) (%d)", bh.columns, bh.rows)
+	}
+
+	digest, err := bh.Digest()
+	log.PanicIf(err)
+
+	if digest.String() != testDigestSmall {
+		t.Fatalf("digest not correct: [%s]", digest.String())
+	}
+}
+
+func TestNew__WithHashbits(t *testing.T) {
+	f, i := getTestImage(testImagePng1Small)
+	defer f.Close()
+
+	bh, err := New(i, WithHashbits(8))
+	log.PanicIf(err)
+
+	digest, err := bh.Digest()
+	log.PanicIf(err)
+
+	if digest.Hashbits() != 8 || digest.Len() != 64 {
+		t.Fatalf("digest not the right size: (%d) (%d)", digest.Hashbits(), digest.Len())
+	}
+}
+
+func TestNew__InvalidHashbits(t *testing.T) {
+	f, i := getTestImage(testImagePng1Small)
+	defer f.Close()
+
+	for _, hashbits := range []int{-4, 0} {
+		_, err := New(i, WithHashbits(hashbits))
+		if err != ErrInvalidHashbits {
+			t.Fatalf("expected invalid-hashbits error for (%d): %v", hashbits, err)
+		}
+	}
+}
+
+func TestNew__UnalignedHashbits(t *testing.T) {
+	f, i := getTestImage(testImagePng1Small)
+	defer f.Close()
+
+	for _, hashbits := range []int{1, 3, 6, 10, 14, 15} {
+		bh, err := New(i, WithHashbits(hashbits))
+		log.PanicIf(err)
+
+		digest, err := bh.Digest()
+		log.PanicIf(err)
+
+		bitCount := hashbits * hashbits
+		if digest.Len() != bitCount || digest.Hashbits() != hashbits {
+			t.Fatalf("digest not the right size for (%d): (%d) (%d)", hashbits, digest.Len(), digest.Hashbits())
+		}
+
+		hexdigest := digest.String()
+		if len(hexdigest) != (bitCount+3)/4 {
+			t.Fatalf("hex-digest not the right size for (%d): [%s]", hashbits, hexdigest)
+		}
+
+		parsed, err := ParseDigest(hexdigest, hashbits)
+		log.PanicIf(err)
+
+		if parsed.Equal(digest) != true {
+			t.Fatalf("digest did not round-trip for (%d): [%s]", hashbits, hexdigest)
+		}
+	}
+}
+
+func TestNew__WithGridSize(t *testing.T) {
+	// The left half is dark and the right half is bright, so every row of a
+	// 16x8 grid reads 0000000011111111.
+
+	i := image.NewGray(image.Rect(0, 0, 160, 40))
+	for y := 0; y < 40; y++ {
+		for x := 80; x < 160; x++ {
+			i.SetGray(x, y, color.Gray{Y: 255})
+		}
+	}
+
+	bh, err := New(i, WithGridSize(16, 8))
+	log.PanicIf(err)
+
+	digest, err := bh.Digest()
+	log.PanicIf(err)
+
+	if digest.Columns() != 16 || digest.Rows() != 8 || digest.Len() != 128 {
+		t.Fatalf("digest not the right size: (%d) (%d) (%d)", digest.Columns(), digest.Rows(), digest.Len())
+	} else if digest.Hashbits() != 0 {
+		t.Fatalf("hashbits of non-square grid not correct: (%d)", digest.Hashbits())
+	}
+
+	expected := "00ff00ff00ff00ff00ff00ff00ff00ff"
+	if digest.String() != expected {
+		t.Fatalf("digest not correct: [%s]", digest)
+	}
+}
+
+func TestNew__WithGridSize__Square(t *testing.T) {
+	f, i := getTestImage(testImagePng1Small)
+	defer f.Close()
+
+	bh, err := New(i, WithGridSize(16, 16))
+	log.PanicIf(err)
+
+	digest, err := bh.Digest()
+	log.PanicIf(err)
+
+	if digest.String() != testDigestSmall {
+		t.Fatalf("digest not correct: [%s]", digest)
+	} else if digest.Hashbits() != 16 {
+		t.Fatalf("hashbits not correct: (%d)", digest.Hashbits())
+	}
+}
+
+func TestNew__WithGridSize__Invalid(t *testing.T) {
+	f, i := getTestImage(testImagePng1Small)
+	defer f.Close()
+
+	_, err := New(i, WithGridSize(16, -6))
+	if err != ErrInvalidHashbits {
+		t.Fatalf("expected invalid-hashbits error: %v", err)
+	}
+
+	_, err = New(i, WithGridSize(0, 16))
+	if err != ErrInvalidHashbits {
+		t.Fatalf("expected invalid-hashbits error: %v", err)
+	}
+
+	// The image is only 67 pixels high.
+	_, err = New(i, WithGridSize(8, 68))
+	if err != ErrImageTooSmall {
+		t.Fatalf("expected image-too-small error: %v", err)
+	}
+}
+
+func TestTranslateBlocksToBits__UnevenBands(t *testing.T) {
+	f, bh := getTestBh(testImagePng1Small)
+	defer f.Close()
+
+	// Ten blocks are split into bands of two, three, two, and three blocks.
+	// None of them may be left out.
+
+	blocks := []float64{
+		1, 2,
+		1, 2, 3,
+		1, 2,
+		1, 2, 3,
+	}
+
+	bits := bh.translateBlocksToBits(blocks, 1.0)
+
+	expected := []int{0, 1, 0, 0, 1, 0, 1, 0, 0, 1}
+	for i, bit := range expected {
+		if bits[i] != bit {
+			t.Fatalf("bits not correct: %v", bits)
+		}
+	}
+}
+
+func TestNew__EmptyImage(t *testing.T) {
+	_, err := New(nil)
+	if err != ErrEmptyImage {
+		t.Fatalf("expected empty-image error for nil image: %v", err)
+	}
+
+	i := image.NewRGBA(image.Rect(0, 0, 0, 10))
+
+	_, err = New(i)
+	if err != ErrEmptyImage {
+		t.Fatalf("expected empty-image error: %v", err)
+	}
+}
+
+func TestNew__ImageTooSmall(t *testing.T) {
+	i := image.NewRGBA(image.Rect(0, 0, 20, 15))
+
+	_, err := New(i, WithHashbits(16))
+	if err != ErrImageTooSmall {
+		t.Fatalf("expected image-too-small error: %v", err)
+	}
+
+	_, err = New(i, WithHashbits(12))
+	log.PanicIf(err)
+}
+
+func TestNewBlockhash__Panics(t *testing.T) {
+	defer func() {
+		state := recover()
+		if state == nil {
+			t.Fatalf("expected panic")
+		}
+
+		err := state.(error)
+		if log.Is(err, ErrInvalidHashbits) != true {
+			t.Fatalf("expected invalid-hashbits error: %v", err)
+		}
+	}()
+
+	f, i := getTestImage(testImagePng1Small)
+	defer f.Close()
+
+	NewBlockhash(i, 0)
+}
+
+func TestHash__SubImage(t *testing.T) {
+	f, i := getTestImage(testImagePng1Small)
+	defer f.Close()
+
+	crop := image.Rect(13, 7, 13+64, 7+48)
+
+	sub := i.(interface {
+		SubImage(r image.Rectangle) image.Image
+	}).SubImage(crop)
+
+	// Copy the same pixels into a fresh image whose origin is (0, 0).
+
+	copied := image.NewNRGBA(image.Rect(0, 0, crop.Dx(), crop.Dy()))
+	for y := 0; y < crop.Dy(); y++ {
+		for x := 0; x < crop.Dx(); x++ {
+			copied.Set(x, y, sub.At(crop.Min.X+x, crop.Min.Y+y))
+		}
+	}
+
+	bh := NewBlockhash(sub, 16)
+	subDigest := bh.Hexdigest()
+
+	bh = NewBlockhash(copied, 16)
+	copiedDigest := bh.Hexdigest()
+
+	if subDigest != copiedDigest {
+		t.Fatalf("sub-image digest does not match copied digest: [%s] != [%s]", subDigest, copiedDigest)
+	}
+
+	// Make sure that we actually hashed the crop and not the whole image.
+
+	bh = NewBlockhash(i, 16)
+	if bh.Hexdigest() == subDigest {
+		t.Fatalf("sub-image digest matches the digest of the whole image")
+	}
+}
+
+func TestHash__NonZeroOrigin(t *testing.T) {
+	f, i := getTestImage(testImagePng1Small)
+	defer f.Close()
+
+	r := i.Bounds()
+
+	// Shift the whole image so that its bounds no longer start at (0, 0).
+
+	shifted := image.NewNRGBA(r.Add(image.Point{X: -30, Y: 45}))
+	for y := r.Min.Y; y < r.Max.Y; y++ {
+		for x := r.Min.X; x < r.Max.X; x++ {
+			shifted.Set(x-30, y+45, i.At(x, y))
+		}
+	}
+
+	bh := NewBlockhash(shifted, 16)
+	actual := bh.Hexdigest()
+
+	if actual != testDigestSmall {
+		t.Fatalf("digest of shifted image not correct: [%s]", actual)
+	}
+}
+
+func TestHash__Quick__EvenSize(t *testing.T) {
+	f, i := getTestImage(testImagePng1SmallEven)
+	defer f.Close()
+
+	// When the image divides evenly into blocks, there are no fractional
+	// pixels and both methods have to agree.
+
+	bh, err := New(i)
+	log.PanicIf(err)
+
+	precise, err := bh.Digest()
+	log.PanicIf(err)
+
+	bh, err = New(i, WithMethod(MethodQuick))
+	log.PanicIf(err)
+
+	quick, err := bh.Digest()
+	log.PanicIf(err)
+
+	if quick.Equal(precise) != true {
+		t.Fatalf("quick digest does not match precise digest: [%s] != [%s]", quick, precise)
+	}
+}
+
+func TestHash__Quick__Reference(t *testing.T) {
+	// These were calculated with blockhash_even() from the reference Python
+	// implementation (blockhash-python), reading the PNGs with a decoder of
+	// its own. The images are 100x67, so every block leaves pixels over.
+
+	cases := []struct {
+		filename string
+		hashbits int
+		expected string
+	}{
+		{testImagePng1Small, 8, "7e034f383f307e30"},
+		{testImagePng1Small, 16, "1ffe1fff007f000033ff3c3f0f8007c03ffa1fce0f840e083ffc1ffc0fc00380"},
+		{testImagePng1SmallAlpha, 8, "7e034f383f303c53"},
+		{testImagePng1SmallAlpha, 16, "1ffe1fff007f000033ff3c3f0f8007c03ffa1fde0f8406080ff80fe112033f8f"},
+	}
+
+	for _, c := range cases {
+		f, i := getTestImage(c.filename)
+
+		bh, err := New(i, WithHashbits(c.hashbits), WithMethod(MethodQuick))
+		log.PanicIf(err)
+
+		digest, err := bh.Digest()
+		log.PanicIf(err)
+
+		f.Close()
+
+		if digest.String() != c.expected {
+			t.Fatalf("quick digest for [%s] (%d) not correct: [%s] != [%s]", c.filename, c.hashbits, digest, c.expected)
+		}
+	}
+}
+
+func TestHash__Quick__Remainder(t *testing.T) {
+	// Every row of the 4x4 grid reads (30, 20, 10, 0), so each band gets
+	// a median of 15 and the bits (1, 1, 0, 0). The bright fifth row and
+	// column are only visible to the precise method.
+
+	i := image.NewGray(image.Rect(0, 0, 5, 5))
+	for y := 0; y < 5; y++ {
+		for x := 0; x < 5; x++ {
+			if x == 4 || y == 4 {
+				i.SetGray(x, y, color.Gray{Y: 255})
+			} else {
+				i.SetGray(x, y, color.Gray{Y: uint8((3 - x) * 10)})
+			}
+		}
+	}
+
+	bh, err := New(i, WithHashbits(4), WithMethod(MethodQuick))
+	log.PanicIf(err)
+
+	quick, err := bh.Digest()
+	log.PanicIf(err)
+
+	if quick.String() != "cccc" {
+		t.Fatalf("quick digest not correct: [%s]", quick)
+	}
+
+	bh, err = New(i, WithHashbits(4))
+	log.PanicIf(err)
+
+	precise, err := bh.Digest()
+	log.PanicIf(err)
+
+	if precise.Equal(quick) == true {
+		t.Fatalf("precise digest unexpectedly matches quick digest")
+	}
+}
+
+func TestNew__InvalidMethod(t *testing.T) {
+	f, i := getTestImage(testImagePng1Small)
+	defer f.Close()
+
+	_, err := New(i, WithMethod(Method(99)))
+	if err != ErrInvalidMethod {
+		t.Fatalf("expected invalid-method error: %v", err)
+	}
+}
@@ -0,0 +1,277 @@
+package blockhash
+
+import (
+	"context"
+	"image"
+	"math"
+	"sort"
+	"sync"
+
+	"github.com/dsoprea/go-logging"
+)
+
+const (
+	// cancellationCheckRows is how many rows of pixels are read between
+	// checks of the context. Checking every row would be wasteful for narrow
+	// images, and very wide images still only read a few MB between checks.
+	cancellationCheckRows = 32
+)
+
+// blockBuffers is the scratch space used to aggregate an image into blocks.
+// They are pooled so that hashing many images doesn't allocate them every
+// time; the slices grow as required and are then reused.
+type blockBuffers struct {
+	blocks       []float64
+	row          []uint32
+	blocksLeft   []int
+	blocksRight  []int
+	weightsLeft  []float64
+	weightsRight []float64
+}
+
+var (
+	blockBuffersPool = sync.Pool{
+		New: func() interface{} {
+			return new(blockBuffers)
+		},
+	}
+)
+
+// getBlockBuffers takes buffers from the pool. They must be returned with
+// putBlockBuffers() once the blocks are no longer needed.
+func getBlockBuffers() *blockBuffers {
+	return blockBuffersPool.Get().(*blockBuffers)
+}
+
+func putBlockBuffers(bb *blockBuffers) {
+	blockBuffersPool.Put(bb)
+}
+
+// prepare sizes the buffers for an image of the given width and the given
+// number of blocks. The blocks are zeroed; everything else will be
+// overwritten anyway.
+func (bb *blockBuffers) prepare(width, blockCount int) {
+	if cap(bb.blocks) < blockCount {
+		bb.blocks = make([]float64, blockCount)
+	} else {
+		bb.blocks = bb.blocks[:blockCount]
+		for i := range bb.blocks {
+			bb.blocks[i] = 0
+		}
+	}
+
+	if cap(bb.row) < width {
+		bb.row = make([]uint32, width)
+		bb.blocksLeft = make([]int, width)
+		bb.blocksRight = make([]int, width)
+		bb.weightsLeft = make([]float64, width)
+		bb.weightsRight = make([]float64, width)
+	} else {
+		bb.row = bb.row[:width]
+		bb.blocksLeft = bb.blocksLeft[:width]
+		bb.blocksRight = bb.blocksRight[:width]
+		bb.weightsLeft = bb.weightsLeft[:width]
+		bb.weightsRight = bb.weightsRight[:width]
+	}
+}
+
+// validateImage checks that the image can be divided into a grid of `columns`
+// by `rows` blocks.
+func validateImage(img image.Image, columns, rows int) (err error) {
+	if img == nil || img.Bounds().Empty() == true {
+		return ErrEmptyImage
+	}
+
+	r := img.Bounds()
+	if r.Dx() < columns || r.Dy() < rows {
+		return ErrImageTooSmall
+	}
+
+	return nil
+}
+
+// getPixelsPerBlock returns the number of pixels that contributed to each
+// block for the given method.
+func getPixelsPerBlock(pr *pixelReader, method Method, columns, rows int) float64 {
+	width, height := pr.size()
+
+	if method == MethodQuick {
+		return float64((width / columns) * (height / rows))
+	}
+
+	blockWidth := float64(width) / float64(columns)
+	blockHeight := float64(height) / float64(rows)
+
+	return blockWidth * blockHeight
+}
+
+// getBlocksForMethod sums the pixels of the image into blocks using the given
+// method. The returned slice belongs to the buffers. The context's error is
+// returned if it is canceled before all of the rows have been read.
+func getBlocksForMethod(ctx context.Context, pr *pixelReader, method Method, columns, rows int, bb *blockBuffers) (blocks []float64, err error) {
+	if method == MethodQuick {
+		return getBlocksQuick(ctx, pr, columns, rows, bb)
+	}
+
+	return getBlocks(ctx, pr, columns, rows, bb)
+}
+
+// getBlocks sums the pixels of the image into a grid of `columns` by `rows`
+// blocks. Pixels that straddle a block boundary are split between the
+// neighbouring blocks by weight. The blocks are returned row by row.
+func getBlocks(ctx context.Context, pr *pixelReader, columns, rows int, bb *blockBuffers) (blocks []float64, err error) {
+	width, height := pr.size()
+
+	isEvenX := (width % columns) == 0
+	isEvenY := (height % rows) == 0
+
+	bb.prepare(width, columns*rows)
+	blocks = bb.blocks
+
+	blockWidth := float64(width) / float64(columns)
+	blockHeight := float64(height) / float64(rows)
+
+	// The horizontal blocks and weights are the same for every row, so only
+	// calculate them once.
+
+	blocksLeft := bb.blocksLeft
+	blocksRight := bb.blocksRight
+	weightsLeft := bb.weightsLeft
+	weightsRight := bb.weightsRight
+
+	for x := 0; x < width; x++ {
+		if isEvenX {
+			blocksRight[x] = int(math.Floor(float64(x) / blockWidth))
+			blocksLeft[x] = blocksRight[x]
+
+			weightsLeft[x] = 1.0
+			weightsRight[x] = 0.0
+		} else {
+			xMod := math.Mod((float64(x) + 1.0), blockWidth)
+			xInt, xFrac := math.Modf(xMod)
+
+			weightsLeft[x] = (1.0 - xFrac)
+			weightsRight[x] = (xFrac)
+
+			if xInt > 0.0 || (x+1) == width {
+				blocksRight[x] = int(math.Floor(float64(x) / blockWidth))
+				blocksLeft[x] = blocksRight[x]
+			} else {
+				blocksLeft[x] = int(math.Floor(float64(x) / blockWidth))
+				blocksRight[x] = int(math.Ceil(float64(x) / blockWidth))
+			}
+		}
+	}
+
+	row := bb.row
+
+	for y := 0; y < height; y++ {
+		if y%cancellationCheckRows == 0 {
+			if err := ctx.Err(); err != nil {
+				return nil, err
+			}
+		}
+
+		var weightTop, weightBottom float64
+		var blockTop, blockBottom int
+
+		if isEvenY {
+			blockTop = int(math.Floor(float64(y) / blockHeight))
+			blockBottom = blockTop
+
+			weightTop = 1.0
+			weightBottom = 0.0
+		} else {
+			yMod := math.Mod((float64(y) + 1.0), blockHeight)
+			yInt, yFrac := math.Modf(yMod)
+
+			weightTop = (1.0 - yFrac)
+			weightBottom = yFrac
+
+			// y_int will be 0 on bottom/right borders and on block boundaries
+			if yInt > 0.0 || (y+1) == height {
+				blockTop = int(math.Floor(float64(y) / blockHeight))
+				blockBottom = blockTop
+			} else {
+				blockTop = int(math.Floor(float64(y) / blockHeight))
+				blockBottom = int(math.Ceil(float64(y) / blockHeight))
+			}
+
+		}
+
+		pr.readRow(y, row)
+
+		for x, value := range row {
+			blockLeft := blocksLeft[x]
+			blockRight := blocksRight[x]
+			weightLeft := weightsLeft[x]
+			weightRight := weightsRight[x]
+
+			blocks[blockTop*columns+blockLeft] += float64(value) * weightTop * weightLeft
+			blocks[blockTop*columns+blockRight] += float64(value) * weightTop * weightRight
+			blocks[blockBottom*columns+blockLeft] += float64(value) * weightBottom * weightLeft
+			blocks[blockBottom*columns+blockRight] += float64(value) * weightBottom * weightRight
+		}
+	}
+
+	return blocks, nil
+}
+
+// getBlocksQuick sums whole pixels into non-overlapping blocks of
+// (width / columns) by (height / rows) pixels. Any pixels beyond the last full
+// block on the right and bottom edges are not considered.
+func getBlocksQuick(ctx context.Context, pr *pixelReader, columns, rows int, bb *blockBuffers) (blocks []float64, err error) {
+	width, height := pr.size()
+
+	blockWidth := width / columns
+	blockHeight := height / rows
+
+	bb.prepare(width, columns*rows)
+
+	blocksInline := bb.blocks
+	row := bb.row
+
+	for y := 0; y < blockHeight*rows; y++ {
+		if y%cancellationCheckRows == 0 {
+			if err := ctx.Err(); err != nil {
+				return nil, err
+			}
+		}
+
+		blockY := y / blockHeight
+
+		pr.readRow(y, row)
+
+		for x := 0; x < blockWidth*columns; x++ {
+			blockX := x / blockWidth
+			blocksInline[blockY*columns+blockX] += float64(row[x])
+		}
+	}
+
+	return blocksInline, nil
+}
+
+// median returns the median of the values without modifying them. For an
+// even number of values, the mean of the two middle values is returned.
+func median(data []float64) float64 {
+	defer func() {
+		if state := recover(); state != nil {
+			log.Panic(state.(error))
+		}
+	}()
+
+	copied := make([]float64, len(data))
+	copy(copied, data)
+	sort.Float64s(copied)
+
+	len_ := len(copied)
+	if len(copied)%2 == 0 {
+		v := (copied[len_/2-1] + copied[len_/2]) / 2.0
+
+		return v
+	} else {
+		v := copied[len_/2]
+
+		return v
+	}
+}
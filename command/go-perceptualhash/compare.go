@@ -0,0 +1,117 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/dsoprea/go-logging"
+
+	"github.com/dsoprea/go-perceptualhash"
+)
+
+const (
+	// Exit codes for the compare command, which follow cmp(1) and diff(1) so
+	// that it can be used in shell scripts.
+	exitMatch   = 0
+	exitNoMatch = 1
+	exitError   = 2
+)
+
+type compareOptions struct {
+	Threshold int `long:"threshold" short:"t" default:"10" description:"Largest number of differing bits that still counts as a match"`
+
+	Positional struct {
+		First  string `positional-arg-name:"FIRST" description:"Image file-path or digest"`
+		Second string `positional-arg-name:"SECOND" description:"Image file-path or digest"`
+	} `positional-args:"true" required:"true"`
+}
+
+const (
+	compareDescription = `Compare two images and print the number of bits that differ between their digests, their similarity, and whether they match.
+
+Either argument can be a digest instead of an image: a hex-digest (with the grid given by --bits or --grid) or the text form, such as "phash:8x8:b593c0690001ffff".
+
+The exit code is 0 if they match, 1 if they don't, and 2 if there was an error.`
+)
+
+// loadCompareDigest hashes the image at the given path or, if there's no such
+// file, parses the argument as a digest.
+func loadCompareDigest(hasher blockhash.Hasher, argument string, columns, rows int) (digest blockhash.Digest, err error) {
+	_, err = os.Stat(argument)
+	if err == nil {
+		return hashFile(hasher, argument)
+	} else if os.IsNotExist(err) == false {
+		return blockhash.Digest{}, err
+	}
+
+	if strings.Contains(argument, ":") == true {
+		err = digest.UnmarshalText([]byte(argument))
+	} else {
+		digest, err = blockhash.ParseGridDigest(argument, columns, rows)
+	}
+
+	if err != nil {
+		return blockhash.Digest{}, log.Errorf("not an image file or a (%dx%d) digest: [%s]", columns, rows, argument)
+	}
+
+	return digest, nil
+}
+
+// compare prints how the two arguments compare and returns true if they
+// match.
+func compare(o *options, co *compareOptions) (matched bool, err error) {
+	defer func() {
+		if state := recover(); state != nil {
+			err = log.Wrap(state.(error))
+		}
+	}()
+
+	hasher, err := newHasher(o)
+	log.PanicIf(err)
+
+	algorithm, err := blockhash.ParseAlgorithm(o.Algorithm)
+	log.PanicIf(err)
+
+	columns, rows, err := gridSize(o, algorithm)
+	log.PanicIf(err)
+
+	first, err := loadCompareDigest(hasher, co.Positional.First, columns, rows)
+	log.PanicIf(err)
+
+	second, err := loadCompareDigest(hasher, co.Positional.Second, columns, rows)
+	log.PanicIf(err)
+
+	distance, err := first.Distance(second)
+	log.PanicIf(err)
+
+	similarity := 100.0 * (1.0 - float64(distance)/float64(first.Len()))
+	matched = distance <= co.Threshold
+
+	verdict := "no match"
+	if matched == true {
+		verdict = "match"
+	}
+
+	fmt.Printf("distance:   %d/%d bits\n", distance, first.Len())
+	fmt.Printf("similarity: %.2f%%\n", similarity)
+	fmt.Printf("verdict:    %s (threshold %d)\n", verdict, co.Threshold)
+
+	return matched, nil
+}
+
+// runCompare runs the compare command and exits with the code for the
+// outcome.
+func runCompare(o *options, co *compareOptions) {
+	matched, err := compare(o, co)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "%s\n", err)
+		os.Exit(exitError)
+	}
+
+	if matched == true {
+		os.Exit(exitMatch)
+	}
+
+	os.Exit(exitNoMatch)
+}
@@ -22,9 +22,159 @@ var (
 )
 
 type options struct {
-	Hashbits  int      `long:"bits" short:"b" default:"16" description:"Hash bit length (N^2)"`
-	Filepaths []string `long:"filepath" short:"f" required:"true" description:"Image file-path (provide at least once)"`
+	Algorithm string   `long:"algorithm" short:"a" default:"blockhash" choice:"blockhash" choice:"dhash" choice:"phash" choice:"ahash" choice:"whash" description:"Hash algorithm"`
+	Hashbits  int      `long:"bits" short:"b" description:"Hash bit length (N^2) (default: 16 for blockhash, 8 for the others)"`
+	Grid      string   `long:"grid" short:"g" description:"Non-square grid as COLUMNSxROWS (e.g. 32x8); overrides --bits"`
+	Filepaths []string `long:"filepath" short:"f" description:"Image file-path (provide at least once unless running a command)"`
 	Digest    bool     `long:"digest" short:"d" description:"Just print digest (no filenames)"`
+	Quick     bool     `long:"quick" short:"q" description:"Use the faster, non-overlapping block method"`
+	Level     int      `long:"level" short:"l" default:"3" description:"Number of wavelet decompositions (whash only)"`
+	Index     string   `long:"index" short:"i" description:"Hash-index file that remembers digests so that unchanged files are skipped on later runs"`
+}
+
+var (
+	defaultHashbits = map[blockhash.Algorithm]int{
+		blockhash.AlgorithmBlockhash: blockhash.DefaultHashbits,
+		blockhash.AlgorithmDHash:     blockhash.DefaultDHashHashbits,
+		blockhash.AlgorithmPHash:     blockhash.DefaultPHashHashbits,
+		blockhash.AlgorithmAHash:     blockhash.DefaultAHashHashbits,
+		blockhash.AlgorithmWHash:     blockhash.DefaultWHashHashbits,
+	}
+)
+
+// gridSize returns the grid that digests will be calculated with, which tells
+// us whether a digest in the hash index can be reused.
+func gridSize(o *options, algorithm blockhash.Algorithm) (columns, rows int, err error) {
+	if o.Grid != "" {
+		_, err := fmt.Sscanf(o.Grid, "%dx%d", &columns, &rows)
+		if err != nil {
+			return 0, 0, log.Errorf("grid must look like COLUMNSxROWS: [%s]", o.Grid)
+		}
+
+		return columns, rows, nil
+	} else if o.Hashbits != 0 {
+		return o.Hashbits, o.Hashbits, nil
+	}
+
+	return defaultHashbits[algorithm], defaultHashbits[algorithm], nil
+}
+
+// hashMethod returns the block aggregation method that was asked for.
+func hashMethod(o *options) blockhash.Method {
+	if o.Quick == true {
+		return blockhash.MethodQuick
+	}
+
+	return blockhash.MethodPrecise
+}
+
+func newHasher(o *options) (hasher blockhash.Hasher, err error) {
+	defer func() {
+		if state := recover(); state != nil {
+			err = log.Wrap(state.(error))
+		}
+	}()
+
+	algorithm, err := blockhash.ParseAlgorithm(o.Algorithm)
+	log.PanicIf(err)
+
+	columns, rows, err := gridSize(o, algorithm)
+	log.PanicIf(err)
+
+	hashOptions := []blockhash.Option{
+		blockhash.WithMethod(hashMethod(o)),
+		blockhash.WithLevel(o.Level),
+		blockhash.WithGridSize(columns, rows),
+	}
+
+	hasher, err = blockhash.NewHasher(algorithm, hashOptions...)
+	log.PanicIf(err)
+
+	return hasher, nil
+}
+
+func hashFile(hasher blockhash.Hasher, filepath string) (digest blockhash.Digest, err error) {
+	defer func() {
+		if state := recover(); state != nil {
+			err = log.Wrap(state.(error))
+		}
+	}()
+
+	f, err := os.Open(filepath)
+	log.PanicIf(err)
+
+	defer f.Close()
+
+	image, _, err := image.Decode(f)
+	log.PanicIf(err)
+
+	digest, err = hasher.Hash(image)
+	log.PanicIf(err)
+
+	return digest, nil
+}
+
+// loadHashIndex loads the hash index, if one was given. A corrupt index (e.g.
+// from an interrupted run) is rewritten with whatever could be read from it.
+func loadHashIndex(o *options) (hi *blockhash.HashIndex, err error) {
+	defer func() {
+		if state := recover(); state != nil {
+			err = log.Wrap(state.(error))
+		}
+	}()
+
+	if o.Index == "" {
+		return nil, nil
+	}
+
+	hi, err = blockhash.LoadHashIndexFile(o.Index)
+	if err == blockhash.ErrCorruptHashIndex {
+		fmt.Fprintf(os.Stderr, "Hash index is corrupt; keeping the (%d) entries before the damage: [%s]\n", hi.Len(), o.Index)
+
+		err = hi.SaveFile(o.Index)
+		log.PanicIf(err)
+	} else if err != nil {
+		log.Panic(err)
+	}
+
+	return hi, nil
+}
+
+func newParser(o *options, co *compareOptions) (parser *flags.Parser, err error) {
+	defer func() {
+		if state := recover(); state != nil {
+			err = log.Wrap(state.(error))
+		}
+	}()
+
+	parser = flags.NewParser(o, flags.Default)
+	parser.SubcommandsOptional = true
+
+	_, err = parser.AddCommand("compare", "Compare two images", compareDescription, co)
+	log.PanicIf(err)
+
+	return parser, nil
+}
+
+// parseExitCode returns the exit code for arguments that couldn't be parsed.
+// The parser might fail before it gets as far as the compare command (e.g. on
+// a bad --algorithm), so look for the command in the arguments too; otherwise
+// a script would read the mistake as a mismatch.
+func parseExitCode(parser *flags.Parser, args []string, err error) int {
+	isCompare := parser.Active != nil
+	for _, arg := range args {
+		if arg == "compare" {
+			isCompare = true
+		}
+	}
+
+	if isCompare == false {
+		return 1
+	} else if flagsErr, ok := err.(*flags.Error); ok == true && flagsErr.Type == flags.ErrHelp {
+		return exitMatch
+	}
+
+	return exitError
 }
 
 func main() {
@@ -35,26 +185,75 @@ func main() {
 	}()
 
 	o := new(options)
-	if _, err := flags.Parse(o); err != nil {
+	co := new(compareOptions)
+
+	parser, err := newParser(o, co)
+	log.PanicIf(err)
+
+	if _, err := parser.ParseArgs(os.Args[1:]); err != nil {
+		os.Exit(parseExitCode(parser, os.Args[1:], err))
+	}
+
+	if parser.Active != nil {
+		runCompare(o, co)
+	}
+
+	if len(o.Filepaths) == 0 {
+		fmt.Fprintf(os.Stderr, "the required flag `-f, --filepath' was not specified\n")
 		os.Exit(1)
 	}
 
+	hasher, err := newHasher(o)
+	log.PanicIf(err)
+
+	algorithm, err := blockhash.ParseAlgorithm(o.Algorithm)
+	log.PanicIf(err)
+
+	columns, rows, err := gridSize(o, algorithm)
+	log.PanicIf(err)
+
+	hi, err := loadHashIndex(o)
+	log.PanicIf(err)
+
 	len_ := 0
 	for _, filepath := range o.Filepaths {
 		len_ = int(math.Max(float64(len_), float64(len(filepath))))
 	}
 
 	for _, filepath := range o.Filepaths {
-		f, err := os.Open(filepath)
-		log.PanicIf(err)
+		var digest blockhash.Digest
 
-		defer f.Close()
+		if hi != nil {
+			// Stat before reading so that a change made while we're hashing
+			// is noticed next time.
+			fi, err := os.Stat(filepath)
+			log.PanicIf(err)
 
-		image, _, err := image.Decode(f)
-		log.PanicIf(err)
+			stored, found := hi.Lookup(filepath, fi, hashMethod(o), o.Level)
+			if found == true && stored.Algorithm() == algorithm && stored.Columns() == columns && stored.Rows() == rows {
+				digest = stored
+			} else {
+				digest, err = hashFile(hasher, filepath)
+				log.PanicIf(err)
+
+				entry := blockhash.HashIndexEntry{
+					Path:    filepath,
+					Size:    fi.Size(),
+					ModTime: fi.ModTime(),
+					Digest:  digest,
+					Method:  hashMethod(o),
+					Level:   o.Level,
+				}
+
+				err = hi.AppendFile(o.Index, entry)
+				log.PanicIf(err)
+			}
+		} else {
+			digest, err = hashFile(hasher, filepath)
+			log.PanicIf(err)
+		}
 
-		bh := blockhash.NewBlockhash(image, o.Hashbits)
-		hexdigest := bh.Hexdigest()
+		hexdigest := digest.String()
 
 		if o.Digest {
 			fmt.Println(hexdigest)
@@ -62,4 +261,11 @@ func main() {
 			fmt.Printf("%s%s %s\n", filepath, strings.Repeat(" ", len_-len(filepath)), hexdigest)
 		}
 	}
+
+	// Drop the records of files that have changed once they outnumber the
+	// current ones.
+	if hi != nil && hi.StaleRecords() > hi.Len() {
+		err := hi.SaveFile(o.Index)
+		log.PanicIf(err)
+	}
 }
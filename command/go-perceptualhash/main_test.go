@@ -0,0 +1,59 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/dsoprea/go-logging"
+)
+
+func getTestParseExitCode(args []string) int {
+	parser, err := newParser(new(options), new(compareOptions))
+	log.PanicIf(err)
+
+	_, err = parser.ParseArgs(args)
+	if err == nil {
+		log.Panicf("expected arguments to be rejected: %v", args)
+	}
+
+	return parseExitCode(parser, args, err)
+}
+
+func TestParseExitCode__Compare(t *testing.T) {
+	args := []string{"compare", "--threshold", "bogus", "a.png", "b.png"}
+
+	if code := getTestParseExitCode(args); code != exitError {
+		t.Fatalf("exit code not correct: (%d)", code)
+	}
+}
+
+func TestParseExitCode__CompareMissingArgument(t *testing.T) {
+	args := []string{"compare", "a.png"}
+
+	if code := getTestParseExitCode(args); code != exitError {
+		t.Fatalf("exit code not correct: (%d)", code)
+	}
+}
+
+func TestParseExitCode__OptionBeforeCompare(t *testing.T) {
+	args := []string{"-a", "bogus", "compare", "a.png", "b.png"}
+
+	if code := getTestParseExitCode(args); code != exitError {
+		t.Fatalf("exit code not correct: (%d)", code)
+	}
+}
+
+func TestParseExitCode__CompareHelp(t *testing.T) {
+	args := []string{"compare", "--help"}
+
+	if code := getTestParseExitCode(args); code != exitMatch {
+		t.Fatalf("exit code not correct: (%d)", code)
+	}
+}
+
+func TestParseExitCode__NotCompare(t *testing.T) {
+	args := []string{"-a", "bogus", "-f", "a.png"}
+
+	if code := getTestParseExitCode(args); code != 1 {
+		t.Fatalf("exit code not correct: (%d)", code)
+	}
+}
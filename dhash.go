@@ -0,0 +1,90 @@
+package blockhash
+
+import (
+	"context"
+	"image"
+
+	"github.com/dsoprea/go-logging"
+)
+
+const (
+	// DefaultDHashHashbits is the dHash grid size used when none is given.
+	DefaultDHashHashbits = 8
+)
+
+// DHash calculates difference hashes (dHash). The image is reduced to a grid
+// of (columns + 1) by rows blocks and each bit records whether a block is
+// brighter than the block to its left. This only captures gradients, so it is
+// cheap to calculate and unaffected by uniform changes in brightness.
+type DHash struct {
+	columns int
+	rows    int
+	method  Method
+}
+
+// NewDHash returns a dHash hasher. The grid size defaults to
+// DefaultDHashHashbits.
+func NewDHash(opts ...Option) (dh *DHash, err error) {
+	o := newOptions(DefaultDHashHashbits, opts)
+
+	err = o.validate()
+	if err != nil {
+		return nil, err
+	}
+
+	dh = &DHash{
+		columns: o.columns,
+		rows:    o.rows,
+		method:  o.method,
+	}
+
+	return dh, nil
+}
+
+// Hash calculates the digest of the given image.
+func (dh *DHash) Hash(img image.Image) (digest Digest, err error) {
+	return dh.HashContext(context.Background(), img)
+}
+
+// HashContext calculates the digest of the given image. The context's error is
+// returned if it is canceled while the image is being read.
+func (dh *DHash) HashContext(ctx context.Context, img image.Image) (digest Digest, err error) {
+	defer func() {
+		if state := recover(); state != nil {
+			err = log.Wrap(state.(error))
+		}
+	}()
+
+	// We need one more block in each row than there are bits.
+	columns := dh.columns + 1
+	rows := dh.rows
+
+	err = validateImage(img, columns, rows)
+	if err != nil {
+		return Digest{}, err
+	}
+
+	pr := newPixelReader(img)
+	bb := getBlockBuffers()
+	defer putBlockBuffers(bb)
+
+	blocks, err := getBlocksForMethod(ctx, pr, dh.method, columns, rows, bb)
+	if err != nil {
+		return Digest{}, err
+	}
+
+	bits := make([]int, dh.columns*dh.rows)
+
+	for y := 0; y < rows; y++ {
+		for x := 0; x < dh.columns; x++ {
+			left := blocks[y*columns+x]
+			right := blocks[y*columns+x+1]
+
+			if right > left {
+				bits[y*dh.columns+x] = 1
+			}
+		}
+	}
+
+	return newDigest(AlgorithmDHash, bits, dh.columns, dh.rows), nil
+}
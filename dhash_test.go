@@ -0,0 +1,148 @@
+package blockhash
+
+import (
+	"image"
+	"image/color"
+	"testing"
+
+	"github.com/dsoprea/go-logging"
+)
+
+func getTestRowPatternImage(columnValues []uint8, height int) *image.Gray {
+	i := image.NewGray(image.Rect(0, 0, len(columnValues), height))
+
+	for y := 0; y < height; y++ {
+		for x, value := range columnValues {
+			i.SetGray(x, y, color.Gray{Y: value})
+		}
+	}
+
+	return i
+}
+
+func TestNewDHash(t *testing.T) {
+	dh, err := NewDHash()
+	log.PanicIf(err)
+
+	if dh.columns != DefaultDHashHashbits || dh.rows != DefaultDHashHashbits {
+		t.Fatalf("default hashbits not applied: (%d) (%d)", dh.columns, dh.rows)
+	}
+}
+
+func TestNewDHash__InvalidHashbits(t *testing.T) {
+	_, err := NewDHash(WithHashbits(-8))
+	if err != ErrInvalidHashbits {
+		t.Fatalf("expected invalid-hashbits error: %v", err)
+	}
+}
+
+func TestDHash_Hash(t *testing.T) {
+	// Every row reads (0, 10, 5, 20, 15, 30, 25, 40, 35), so the bits of
+	// every row alternate between brighter and darker: 10101010.
+
+	i := getTestRowPatternImage([]uint8{0, 10, 5, 20, 15, 30, 25, 40, 35}, 8)
+
+	for _, method := range []Method{MethodPrecise, MethodQuick} {
+		dh, err := NewDHash(WithMethod(method))
+		log.PanicIf(err)
+
+		digest, err := dh.Hash(i)
+		log.PanicIf(err)
+
+		if digest.String() != "aaaaaaaaaaaaaaaa" {
+			t.Fatalf("digest not correct: [%s]", digest)
+		} else if digest.Hashbits() != 8 {
+			t.Fatalf("hashbits not correct: (%d)", digest.Hashbits())
+		}
+	}
+}
+
+func TestDHash_Hash__NonSquare(t *testing.T) {
+	i := getTestRowPatternImage([]uint8{0, 10, 5, 20, 15, 30, 25, 40, 35}, 4)
+
+	dh, err := NewDHash(WithGridSize(8, 4))
+	log.PanicIf(err)
+
+	digest, err := dh.Hash(i)
+	log.PanicIf(err)
+
+	if digest.String() != "aaaaaaaa" {
+		t.Fatalf("digest not correct: [%s]", digest)
+	} else if digest.Columns() != 8 || digest.Rows() != 4 {
+		t.Fatalf("grid not correct: (%d) (%d)", digest.Columns(), digest.Rows())
+	}
+}
+
+func TestDHash_Hash__Gradient(t *testing.T) {
+	values := make([]uint8, 90)
+	for x := range values {
+		values[x] = uint8(x * 2)
+	}
+
+	i := getTestRowPatternImage(values, 40)
+
+	dh, err := NewDHash()
+	log.PanicIf(err)
+
+	digest, err := dh.Hash(i)
+	log.PanicIf(err)
+
+	if digest.String() != "ffffffffffffffff" {
+		t.Fatalf("digest of increasing gradient not correct: [%s]", digest)
+	}
+
+	for x := range values {
+		values[x] = uint8(255 - x*2)
+	}
+
+	i = getTestRowPatternImage(values, 40)
+
+	digest, err = dh.Hash(i)
+	log.PanicIf(err)
+
+	if digest.String() != "0000000000000000" {
+		t.Fatalf("digest of decreasing gradient not correct: [%s]", digest)
+	}
+}
+
+func TestDHash_Hash__Similar(t *testing.T) {
+	f, i1 := getTestImage(testImagePng1Small)
+	defer f.Close()
+
+	f2, i2 := getTestImage(testImagePng1SmallEven)
+	defer f2.Close()
+
+	dh, err := NewDHash()
+	log.PanicIf(err)
+
+	d1, err := dh.Hash(i1)
+	log.PanicIf(err)
+
+	d2, err := dh.Hash(i2)
+	log.PanicIf(err)
+
+	distance, err := d1.Distance(d2)
+	log.PanicIf(err)
+
+	if distance > 8 {
+		t.Fatalf("distance between similar images too large: (%d)", distance)
+	}
+}
+
+func TestDHash_Hash__ImageTooSmall(t *testing.T) {
+	dh, err := NewDHash()
+	log.PanicIf(err)
+
+	// We need one more column than there are bits in a row.
+	i := image.NewGray(image.Rect(0, 0, 8, 8))
+
+	_, err = dh.Hash(i)
+	if err != ErrImageTooSmall {
+		t.Fatalf("expected image-too-small error: %v", err)
+	}
+
+	_, err = dh.Hash(nil)
+	if err != ErrEmptyImage {
+		t.Fatalf("expected empty-image error: %v", err)
+	}
+}
@@ -0,0 +1,292 @@
+package blockhash
+
+import (
+	"errors"
+	"math/bits"
+	"strconv"
+)
+
+const (
+	hexCharacters = "0123456789abcdef"
+)
+
+var (
+	// ErrInvalidDigest indicates that a hex-digest could not be parsed for the
+	// given `hashbits`, or that an encoded digest is malformed.
+	ErrInvalidDigest = errors.New("invalid digest")
+
+	// ErrAlgorithmMismatch indicates that two digests were calculated with
+	// different algorithms and can't be compared.
+	ErrAlgorithmMismatch = errors.New("digests were calculated with different algorithms")
+)
+
+// Digest is a computed hash. It retains the individual bits along with the
+// size of the grid (columns and rows) and the algorithm that they were
+// calculated with.
+type Digest struct {
+	algorithm Algorithm
+	columns   int
+	rows      int
+
+	// words holds the bits, 64 to a word, with the first bit in the
+	// most-significant position. The unused bits of the last word are always
+	// zero so that words can be compared directly.
+	words []uint64
+}
+
+// newDigest packs the bits (each zero or one) into a digest.
+func newDigest(algorithm Algorithm, bitString []int, columns, rows int) Digest {
+	words := make([]uint64, wordCount(len(bitString)))
+	for i, bit := range bitString {
+		words[i/64] |= uint64(bit&1) << uint(63-i%64)
+	}
+
+	return Digest{
+		algorithm: algorithm,
+		columns:   columns,
+		rows:      rows,
+		words:     words,
+	}
+}
+
+// wordCount returns the number of words needed for the given number of bits.
+func wordCount(bitCount int) int {
+	return (bitCount + 63) / 64
+}
+
+// ParseDigest parses a hex-digest, as returned by `Hexdigest()`, that was
+// produced with the given `hashbits`. A hex-digest doesn't record the
+// algorithm, so the algorithm of the digest is unknown (zero).
+func ParseDigest(hexdigest string, hashbits int) (digest Digest, err error) {
+	return ParseGridDigest(hexdigest, hashbits, hashbits)
+}
+
+// ParseGridDigest parses a hex-digest that was produced with a grid of the
+// given number of columns and rows.
+func ParseGridDigest(hexdigest string, columns, rows int) (digest Digest, err error) {
+	bitCount := columns * rows
+	if columns <= 0 || rows <= 0 || len(hexdigest) != hexDigits(bitCount) {
+		return Digest{}, ErrInvalidDigest
+	}
+
+	// A word holds sixteen whole digits.
+	words := make([]uint64, wordCount(bitCount))
+
+	for i := 0; i < len(hexdigest); i++ {
+		n, err := strconv.ParseUint(hexdigest[i:i+1], 16, 8)
+		if err != nil {
+			return Digest{}, ErrInvalidDigest
+		}
+
+		words[i/16] |= n << uint(60-(i%16)*4)
+	}
+
+	// The padding at the end of the last digit has to be empty, or the
+	// digest is for a different grid.
+	if padding := len(hexdigest)*4 - bitCount; padding > 0 {
+		last, _ := strconv.ParseUint(hexdigest[len(hexdigest)-1:], 16, 8)
+		if last&(1<<uint(padding)-1) != 0 {
+			return Digest{}, ErrInvalidDigest
+		}
+	}
+
+	digest = Digest{
+		columns: columns,
+		rows:    rows,
+		words:   words,
+	}
+
+	return digest, nil
+}
+
+// DigestFromBytes is the inverse of Bytes(). It returns the digest with the
+// given grid whose bits are packed into `packed`, most-significant bit first.
+// The algorithm may be zero if it isn't known.
+func DigestFromBytes(algorithm Algorithm, packed []byte, columns, rows int) (digest Digest, err error) {
+	if columns <= 0 || rows <= 0 {
+		return Digest{}, ErrInvalidDigest
+	}
+
+	if algorithm != 0 {
+		if _, found := algorithmNames[algorithm]; found == false {
+			return Digest{}, ErrInvalidDigest
+		}
+	}
+
+	bitCount := columns * rows
+	if len(packed) != (bitCount+7)/8 {
+		return Digest{}, ErrInvalidDigest
+	}
+
+	// The padding at the end of the last byte has to be empty.
+	if bitCount%8 != 0 && packed[len(packed)-1]&(0xff>>uint(bitCount%8)) != 0 {
+		return Digest{}, ErrInvalidDigest
+	}
+
+	words := make([]uint64, wordCount(bitCount))
+	for i, b := range packed {
+		words[i/8] |= uint64(b) << uint(56-(i%8)*8)
+	}
+
+	digest = Digest{
+		algorithm: algorithm,
+		columns:   columns,
+		rows:      rows,
+		words:     words,
+	}
+
+	return digest, nil
+}
+
+// DigestFromWords is the inverse of Words(). It returns the digest with the
+// given grid whose bits are packed into `words`, most-significant bit first.
+// The algorithm may be zero if it isn't known.
+func DigestFromWords(algorithm Algorithm, words []uint64, columns, rows int) (digest Digest, err error) {
+	if columns <= 0 || rows <= 0 {
+		return Digest{}, ErrInvalidDigest
+	}
+
+	if algorithm != 0 {
+		if _, found := algorithmNames[algorithm]; found == false {
+			return Digest{}, ErrInvalidDigest
+		}
+	}
+
+	bitCount := columns * rows
+	if len(words) != wordCount(bitCount) {
+		return Digest{}, ErrInvalidDigest
+	}
+
+	// The padding at the end of the last word has to be empty.
+	if bitCount%64 != 0 && words[len(words)-1]&(^uint64(0)>>uint(bitCount%64)) != 0 {
+		return Digest{}, ErrInvalidDigest
+	}
+
+	digest = Digest{
+		algorithm: algorithm,
+		columns:   columns,
+		rows:      rows,
+		words:     append([]uint64(nil), words...),
+	}
+
+	return digest, nil
+}
+
+// Algorithm returns the algorithm that the digest was calculated with. This is
+// zero if it isn't known, such as for a digest parsed from a hex-digest.
+func (d Digest) Algorithm() Algorithm {
+	return d.algorithm
+}
+
+// Hashbits returns the grid size that the digest was calculated with. This is
+// zero if the grid wasn't square; use Columns() and Rows() instead.
+func (d Digest) Hashbits() int {
+	if d.columns != d.rows {
+		return 0
+	}
+
+	return d.columns
+}
+
+// Columns returns the number of columns in the grid that the digest was
+// calculated with.
+func (d Digest) Columns() int {
+	return d.columns
+}
+
+// Rows returns the number of rows in the grid that the digest was calculated
+// with.
+func (d Digest) Rows() int {
+	return d.rows
+}
+
+// Len returns the number of bits in the digest.
+func (d Digest) Len() int {
+	return d.columns * d.rows
+}
+
+// Bit returns the bit (zero or one) at the given offset. Offsets run from the
+// top-left block of the grid, row by row.
+func (d Digest) Bit(i int) int {
+	return int(d.words[i/64]>>uint(63-i%64)) & 1
+}
+
+// Bytes returns the bits packed into bytes, most-significant bit first. This
+// is the binary equivalent of `String()`.
+func (d Digest) Bytes() []byte {
+	packed := make([]byte, (d.Len()+7)/8)
+	for i := range packed {
+		packed[i] = byte(d.words[i/8] >> uint(56-(i%8)*8))
+	}
+
+	return packed
+}
+
+// Words returns the bits packed into 64-bit words, most-significant bit
+// first. The unused bits of the last word are zero, so the words of digests
+// with the same grid can be compared (and XORed) directly.
+func (d Digest) Words() []uint64 {
+	return append([]uint64(nil), d.words...)
+}
+
+// String returns the hex-digest. If the number of bits isn't a multiple of
+// four, the last digit is padded with zero bits on the right.
+func (d Digest) String() string {
+	encoded := make([]byte, hexDigits(d.Len()))
+	for i := range encoded {
+		encoded[i] = hexCharacters[(d.words[i/16]>>uint(60-(i%16)*4))&0xf]
+	}
+
+	return string(encoded)
+}
+
+// Equal returns true if both digests have the same grid and bits and weren't
+// calculated with different algorithms.
+func (d Digest) Equal(other Digest) bool {
+	if d.sameGrid(other) == false || d.sameAlgorithm(other) == false {
+		return false
+	}
+
+	for i, word := range d.words {
+		if other.words[i] != word {
+			return false
+		}
+	}
+
+	return true
+}
+
+// Distance returns the number of bits that differ between the two digests
+// (the Hamming distance). ErrAlgorithmMismatch is returned if both digests
+// know their algorithm and they differ.
+func (d Digest) Distance(other Digest) (distance int, err error) {
+	if d.sameGrid(other) == false {
+		return 0, ErrDigestSizeMismatch
+	} else if d.sameAlgorithm(other) == false {
+		return 0, ErrAlgorithmMismatch
+	}
+
+	for i, word := range d.words {
+		distance += bits.OnesCount64(word ^ other.words[i])
+	}
+
+	return distance, nil
+}
+
+// sameGrid returns true if the digests were calculated with the same grid and
+// so can be compared.
+func (d Digest) sameGrid(other Digest) bool {
+	return d.columns == other.columns && d.rows == other.rows && len(d.words) == len(other.words)
+}
+
+// hexDigits returns the number of hex digits needed for the given number of
+// bits.
+func hexDigits(bitCount int) int {
+	return (bitCount + 3) / 4
+}
+
+// sameAlgorithm returns true unless both digests know their algorithm and they
+// differ. Digests from hex-digests can be compared to anything.
+func (d Digest) sameAlgorithm(other Digest) bool {
+	return d.algorithm == 0 || other.algorithm == 0 || d.algorithm == other.algorithm
+}
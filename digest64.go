@@ -0,0 +1,76 @@
+package blockhash
+
+import (
+	"errors"
+	"math/bits"
+	"strconv"
+)
+
+const (
+	// digest64Hashbits is the only grid size whose digests fit in a Digest64.
+	digest64Hashbits = 8
+)
+
+var (
+	// ErrNotDigest64 indicates that a digest wasn't calculated with an 8x8 grid
+	// and so can't be represented as a Digest64.
+	ErrNotDigest64 = errors.New("digest is not 8x8")
+)
+
+// Digest64 is an 8x8 digest packed into an integer, with the first bit (the
+// top-left block) in the most-significant position. Comparing two of them is
+// a single XOR and population count, which makes it the cheapest way to keep
+// very large numbers of digests in memory. The algorithm isn't recorded.
+type Digest64 uint64
+
+// Digest64 returns the digest as a Digest64. ErrNotDigest64 is returned
+// unless the digest was calculated with an 8x8 grid, which is the default for
+// every algorithm except blockhash.
+func (d Digest) Digest64() (value Digest64, err error) {
+	if d.columns != digest64Hashbits || d.rows != digest64Hashbits || len(d.words) != 1 {
+		return 0, ErrNotDigest64
+	}
+
+	return Digest64(d.words[0]), nil
+}
+
+// ParseDigest64 parses the 16-digit hex-digest of an 8x8 digest.
+func ParseDigest64(hexdigest string) (value Digest64, err error) {
+	if len(hexdigest) != 16 {
+		return 0, ErrInvalidDigest
+	}
+
+	n, err := strconv.ParseUint(hexdigest, 16, 64)
+	if err != nil {
+		return 0, ErrInvalidDigest
+	}
+
+	return Digest64(n), nil
+}
+
+// Distance returns the number of bits that differ between the two digests
+// (the Hamming distance).
+func (d Digest64) Distance(other Digest64) int {
+	return bits.OnesCount64(uint64(d ^ other))
+}
+
+// String returns the hex-digest, which is the same as that of the Digest that
+// it came from.
+func (d Digest64) String() string {
+	var encoded [16]byte
+
+	for i := range encoded {
+		encoded[i] = hexCharacters[(d>>uint(60-i*4))&0xf]
+	}
+
+	return string(encoded[:])
+}
+
+// Digest returns the equivalent 8x8 Digest. Its algorithm is unknown.
+func (d Digest64) Digest() Digest {
+	return Digest{
+		columns: digest64Hashbits,
+		rows:    digest64Hashbits,
+		words:   []uint64{uint64(d)},
+	}
+}
@@ -0,0 +1,164 @@
+package blockhash
+
+import (
+	"math/rand"
+	"testing"
+
+	"github.com/dsoprea/go-logging"
+)
+
+func TestDigest_Digest64(t *testing.T) {
+	f, i := getTestImage(testImagePng1Small)
+	defer f.Close()
+
+	for _, algorithm := range Algorithms() {
+		hasher, err := NewHasher(algorithm, WithHashbits(8))
+		log.PanicIf(err)
+
+		digest, err := hasher.Hash(i)
+		log.PanicIf(err)
+
+		value, err := digest.Digest64()
+		log.PanicIf(err)
+
+		if value.String() != digest.String() {
+			t.Fatalf("%s: hex-digest not correct: [%s] != [%s]", algorithm, value, digest)
+		} else if value.Digest().Equal(digest) != true {
+			t.Fatalf("%s: digest did not round-trip: [%s]", algorithm, value.Digest())
+		}
+	}
+}
+
+func TestDigest_Digest64__NotDigest64(t *testing.T) {
+	f, bh := getTestBh(testImagePng1Small)
+	defer f.Close()
+
+	digest, err := bh.Digest()
+	log.PanicIf(err)
+
+	_, err = digest.Digest64()
+	if err != ErrNotDigest64 {
+		t.Fatalf("expected not-digest64 error: %v", err)
+	}
+
+	// The same number of bits but not the same grid.
+
+	wide, err := ParseGridDigest("0123456789abcdef", 16, 4)
+	log.PanicIf(err)
+
+	_, err = wide.Digest64()
+	if err != ErrNotDigest64 {
+		t.Fatalf("expected not-digest64 error: %v", err)
+	}
+}
+
+func TestParseDigest64(t *testing.T) {
+	value, err := ParseDigest64("0123456789abcdef")
+	log.PanicIf(err)
+
+	if value != 0x0123456789abcdef {
+		t.Fatalf("value not correct: (%x)", uint64(value))
+	} else if value.String() != "0123456789abcdef" {
+		t.Fatalf("hex-digest not zero-padded: [%s]", value)
+	}
+
+	for _, hexdigest := range []string{"", "0123", "0123456789abcdeg", "0123456789abcdef0"} {
+		_, err := ParseDigest64(hexdigest)
+		if err != ErrInvalidDigest {
+			t.Fatalf("expected invalid-digest error for [%s]: %v", hexdigest, err)
+		}
+	}
+}
+
+func TestDigest64_Distance(t *testing.T) {
+	r := rand.New(rand.NewSource(1))
+
+	for n := 0; n < 100; n++ {
+		a := Digest64(r.Uint64())
+		b := Digest64(r.Uint64())
+
+		expected, err := a.Digest().Distance(b.Digest())
+		log.PanicIf(err)
+
+		if a.Distance(b) != expected {
+			t.Fatalf("distance not correct: (%d) != (%d)", a.Distance(b), expected)
+		}
+
+		hexDistance, err := Distance(a.String(), b.String())
+		log.PanicIf(err)
+
+		if hexDistance != expected {
+			t.Fatalf("hex distance not correct: (%d) != (%d)", hexDistance, expected)
+		}
+	}
+}
+
+func getBenchmarkDigestPair(b *testing.B) (Digest, Digest) {
+	r := rand.New(rand.NewSource(1))
+
+	return Digest64(r.Uint64()).Digest(), Digest64(r.Uint64()).Digest()
+}
+
+func BenchmarkDigest_Distance(b *testing.B) {
+	d1, d2 := getBenchmarkDigestPair(b)
+
+	b.ResetTimer()
+
+	for i := 0; i < b.N; i++ {
+		_, err := d1.Distance(d2)
+		log.PanicIf(err)
+	}
+}
+
+func BenchmarkDigest64_Distance(b *testing.B) {
+	d1, d2 := getBenchmarkDigestPair(b)
+
+	v1, err := d1.Digest64()
+	log.PanicIf(err)
+
+	v2, err := d2.Digest64()
+	log.PanicIf(err)
+
+	b.ResetTimer()
+
+	for i := 0; i < b.N; i++ {
+		v1.Distance(v2)
+	}
+}
+
+func BenchmarkDistance__Hex(b *testing.B) {
+	d1, d2 := getBenchmarkDigestPair(b)
+
+	h1 := d1.String()
+	h2 := d2.String()
+
+	b.ResetTimer()
+
+	for i := 0; i < b.N; i++ {
+		_, err := Distance(h1, h2)
+		log.PanicIf(err)
+	}
+}
+
+func BenchmarkDigest_String(b *testing.B) {
+	d1, _ := getBenchmarkDigestPair(b)
+
+	b.ResetTimer()
+
+	for i := 0; i < b.N; i++ {
+		_ = d1.String()
+	}
+}
+
+func BenchmarkDigest64_String(b *testing.B) {
+	d1, _ := getBenchmarkDigestPair(b)
+
+	v1, err := d1.Digest64()
+	log.PanicIf(err)
+
+	b.ResetTimer()
+
+	for i := 0; i < b.N; i++ {
+		_ = v1.String()
+	}
+}
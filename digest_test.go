@@ -0,0 +1,355 @@
+package blockhash
+
+import (
+	"bytes"
+	"math/rand"
+	"reflect"
+	"testing"
+
+	"github.com/dsoprea/go-logging"
+)
+
+const (
+	testDigestSmall = "1ffc3fff00fe000031ff3e3f0f8007c03fff1f8d0f9806003ffc3ff80f0400f0"
+)
+
+func TestBlockhash_Digest(t *testing.T) {
+	f, bh := getTestBh(testImagePng1Small)
+	defer f.Close()
+
+	digest, err := bh.Digest()
+	log.PanicIf(err)
+
+	if digest.String() != testDigestSmall {
+		t.Fatalf("digest not correct: [%s]", digest.String())
+	} else if digest.Hashbits() != 16 {
+		t.Fatalf("hashbits not correct: (%d)", digest.Hashbits())
+	} else if digest.Len() != 256 {
+		t.Fatalf("length not correct: (%d)", digest.Len())
+	}
+}
+
+func TestParseDigest(t *testing.T) {
+	digest, err := ParseDigest(testDigestSmall, 16)
+	log.PanicIf(err)
+
+	if digest.String() != testDigestSmall {
+		t.Fatalf("digest did not round-trip: [%s]", digest.String())
+	}
+
+	f, bh := getTestBh(testImagePng1Small)
+	defer f.Close()
+
+	computed, err := bh.Digest()
+	log.PanicIf(err)
+
+	if digest.Equal(computed) != true {
+		t.Fatalf("parsed digest not equal to computed digest")
+	}
+}
+
+func TestParseDigest__WrongLength(t *testing.T) {
+	_, err := ParseDigest(testDigestSmall, 8)
+	if err != ErrInvalidDigest {
+		t.Fatalf("expected invalid-digest error: %v", err)
+	}
+}
+
+func TestParseDigest__InvalidHex(t *testing.T) {
+	_, err := ParseDigest("000000000000000000000000000000000000000000000000000000000000000g", 16)
+	if err != ErrInvalidDigest {
+		t.Fatalf("expected invalid-digest error: %v", err)
+	}
+}
+
+func TestDigest_Bit(t *testing.T) {
+	digest, err := ParseDigest("a000000000000000", 8)
+	log.PanicIf(err)
+
+	expected := []int{1, 0, 1, 0, 0}
+	for i, bit := range expected {
+		if digest.Bit(i) != bit {
+			t.Fatalf("bit (%d) not correct: (%d)", i, digest.Bit(i))
+		}
+	}
+}
+
+func TestDigest_Bytes(t *testing.T) {
+	digest, err := ParseDigest("0123456789abcdef", 8)
+	log.PanicIf(err)
+
+	expected := []byte{0x01, 0x23, 0x45, 0x67, 0x89, 0xab, 0xcd, 0xef}
+	if bytes.Equal(digest.Bytes(), expected) != true {
+		t.Fatalf("bytes not correct: %x", digest.Bytes())
+	}
+}
+
+func TestDigest_Equal(t *testing.T) {
+	d1, err := ParseDigest("0123456789abcdef", 8)
+	log.PanicIf(err)
+
+	d2, err := ParseDigest("0123456789abcdef", 8)
+	log.PanicIf(err)
+
+	d3, err := ParseDigest("0123456789abcdee", 8)
+	log.PanicIf(err)
+
+	if d1.Equal(d2) != true {
+		t.Fatalf("identical digests not equal")
+	} else if d1.Equal(d3) != false {
+		t.Fatalf("different digests equal")
+	}
+}
+
+func TestDigest_Distance(t *testing.T) {
+	d1, err := ParseDigest("0123456789abcdef", 8)
+	log.PanicIf(err)
+
+	d2, err := ParseDigest("f123456789abcdee", 8)
+	log.PanicIf(err)
+
+	distance, err := d1.Distance(d2)
+	log.PanicIf(err)
+
+	if distance != 5 {
+		t.Fatalf("distance not correct: (%d)", distance)
+	}
+
+	hexDistance, err := Distance(d1.String(), d2.String())
+	log.PanicIf(err)
+
+	if hexDistance != distance {
+		t.Fatalf("distance does not agree with hex distance: (%d) != (%d)", distance, hexDistance)
+	}
+}
+
+func TestDigest_Distance__SizeMismatch(t *testing.T) {
+	d1, err := ParseDigest("0123456789abcdef", 8)
+	log.PanicIf(err)
+
+	d2, err := ParseDigest(testDigestSmall, 16)
+	log.PanicIf(err)
+
+	_, err = d1.Distance(d2)
+	if err != ErrDigestSizeMismatch {
+		t.Fatalf("expected size-mismatch error: %v", err)
+	}
+}
+
+func TestParseGridDigest(t *testing.T) {
+	digest, err := ParseGridDigest("00ff00ff", 8, 4)
+	log.PanicIf(err)
+
+	if digest.Columns() != 8 || digest.Rows() != 4 {
+		t.Fatalf("grid not correct: (%d) (%d)", digest.Columns(), digest.Rows())
+	} else if digest.String() != "00ff00ff" {
+		t.Fatalf("digest did not round-trip: [%s]", digest)
+	}
+
+	_, err = ParseGridDigest("00ff00ff", 8, 8)
+	if err != ErrInvalidDigest {
+		t.Fatalf("expected invalid-digest error: %v", err)
+	}
+}
+
+func TestDigest_Distance__GridMismatch(t *testing.T) {
+	wide, err := ParseGridDigest("00ff00ff", 8, 4)
+	log.PanicIf(err)
+
+	tall, err := ParseGridDigest("00ff00ff", 4, 8)
+	log.PanicIf(err)
+
+	// They have the same number of bits but they don't represent the same
+	// blocks.
+
+	_, err = wide.Distance(tall)
+	if err != ErrDigestSizeMismatch {
+		t.Fatalf("expected size-mismatch error: %v", err)
+	} else if wide.Equal(tall) == true {
+		t.Fatalf("digests with different grids should not be equal")
+	}
+}
+
+func TestDigest_String__Unaligned(t *testing.T) {
+	// Nine bits take three digits. The last digit only holds the ninth bit,
+	// in its most-significant position.
+
+	digest := newDigest(0, []int{1, 0, 1, 1, 0, 0, 1, 1, 1}, 3, 3)
+
+	if digest.String() != "b38" {
+		t.Fatalf("digest not correct: [%s]", digest)
+	}
+
+	parsed, err := ParseDigest("b38", 3)
+	log.PanicIf(err)
+
+	if parsed.Equal(digest) != true {
+		t.Fatalf("digest did not round-trip: [%s]", parsed)
+	}
+}
+
+func TestParseDigest__UnalignedPadding(t *testing.T) {
+	// The padding bits of the last digit have to be zero.
+	_, err := ParseDigest("b3c", 3)
+	if err != ErrInvalidDigest {
+		t.Fatalf("expected invalid-digest error: %v", err)
+	}
+
+	_, err = ParseDigest("b380", 3)
+	if err != ErrInvalidDigest {
+		t.Fatalf("expected invalid-digest error: %v", err)
+	}
+}
+
+func TestDigestFromBytes(t *testing.T) {
+	for _, digest := range getTestDigests() {
+		decoded, err := DigestFromBytes(digest.Algorithm(), digest.Bytes(), digest.Columns(), digest.Rows())
+		log.PanicIf(err)
+
+		if decoded.Equal(digest) != true || decoded.Algorithm() != digest.Algorithm() {
+			t.Fatalf("digest did not round-trip: [%s]", decoded)
+		}
+	}
+
+	_, err := DigestFromBytes(0, []byte{0x01, 0x23}, 4, 8)
+	if err != ErrInvalidDigest {
+		t.Fatalf("expected invalid-digest error for wrong length: %v", err)
+	}
+
+	_, err = DigestFromBytes(Algorithm(99), []byte{0x01, 0x23}, 4, 4)
+	if err != ErrInvalidDigest {
+		t.Fatalf("expected invalid-digest error for invalid algorithm: %v", err)
+	}
+}
+
+func TestDigestFromWords(t *testing.T) {
+	for _, digest := range getTestDigests() {
+		decoded, err := DigestFromWords(digest.Algorithm(), digest.Words(), digest.Columns(), digest.Rows())
+		log.PanicIf(err)
+
+		if decoded.Equal(digest) != true || decoded.Algorithm() != digest.Algorithm() {
+			t.Fatalf("digest did not round-trip: [%s]", decoded)
+		}
+	}
+
+	_, err := DigestFromWords(0, []uint64{1, 2}, 8, 8)
+	if err != ErrInvalidDigest {
+		t.Fatalf("expected invalid-digest error for wrong length: %v", err)
+	}
+
+	_, err = DigestFromWords(Algorithm(99), []uint64{1}, 8, 8)
+	if err != ErrInvalidDigest {
+		t.Fatalf("expected invalid-digest error for invalid algorithm: %v", err)
+	}
+
+	// 25 bits leave 39 bits of padding.
+	_, err = DigestFromWords(0, []uint64{1 << 38}, 5, 5)
+	if err != ErrInvalidDigest {
+		t.Fatalf("expected invalid-digest error for padding: %v", err)
+	}
+
+	_, err = DigestFromWords(0, []uint64{1 << 39}, 5, 5)
+	log.PanicIf(err)
+}
+
+func TestDigest_Words(t *testing.T) {
+	digest, err := ParseGridDigest("0123456789abcdeffedcba9876543210aa", 17, 8)
+	log.PanicIf(err)
+
+	words := digest.Words()
+	if reflect.DeepEqual(words, []uint64{0x0123456789abcdef, 0xfedcba9876543210, 0xaa00000000000000}) != true {
+		t.Fatalf("words not correct: %x", words)
+	}
+
+	// The words are a copy.
+	words[0] = 0
+
+	if digest.String() != "0123456789abcdeffedcba9876543210aa" {
+		t.Fatalf("digest was modified: [%s]", digest)
+	}
+}
+
+func TestNewDigest__Packing(t *testing.T) {
+	r := rand.New(rand.NewSource(1))
+
+	// Grids whose bits end in the middle of a word, on a word boundary, and
+	// just after one.
+	grids := [][2]int{{1, 1}, {5, 5}, {8, 8}, {5, 13}, {9, 9}, {16, 16}, {31, 7}}
+
+	for _, grid := range grids {
+		columns, rows := grid[0], grid[1]
+
+		bitString1 := make([]int, columns*rows)
+		bitString2 := make([]int, columns*rows)
+		expectedDistance := 0
+
+		for i := range bitString1 {
+			bitString1[i] = r.Intn(2)
+			bitString2[i] = r.Intn(2)
+
+			if bitString1[i] != bitString2[i] {
+				expectedDistance++
+			}
+		}
+
+		digest1 := newDigest(AlgorithmBlockhash, bitString1, columns, rows)
+		digest2 := newDigest(AlgorithmBlockhash, bitString2, columns, rows)
+
+		for i, bit := range bitString1 {
+			if digest1.Bit(i) != bit {
+				t.Fatalf("(%dx%d) bit (%d) not correct", columns, rows, i)
+			}
+		}
+
+		distance, err := digest1.Distance(digest2)
+		log.PanicIf(err)
+
+		if distance != expectedDistance {
+			t.Fatalf("(%dx%d) distance not correct: (%d) != (%d)", columns, rows, distance, expectedDistance)
+		}
+
+		parsed, err := ParseGridDigest(digest1.String(), columns, rows)
+		log.PanicIf(err)
+
+		if parsed.Equal(digest1) != true {
+			t.Fatalf("(%dx%d) hex-digest did not round-trip: [%s]", columns, rows, digest1)
+		}
+
+		decoded, err := DigestFromBytes(AlgorithmBlockhash, digest1.Bytes(), columns, rows)
+		log.PanicIf(err)
+
+		if decoded.Equal(digest1) != true {
+			t.Fatalf("(%dx%d) bytes did not round-trip: [%s]", columns, rows, digest1)
+		}
+	}
+}
+
+func TestDigest_String__Bits(t *testing.T) {
+	// The last digit is padded on the right, so a digest of any grid reads
+	// the same as the bits written out in order.
+
+	cases := []struct {
+		bitString []int
+		columns   int
+		rows      int
+		expected  string
+	}{
+		{[]int{1}, 1, 1, "8"},
+		{[]int{1, 0, 1}, 3, 1, "a"},
+		{[]int{1, 1, 0, 1}, 2, 2, "d"},
+		{[]int{1, 0, 1, 0, 1}, 5, 1, "a8"},
+		{[]int{1, 1, 0, 0, 1, 1, 0, 1}, 4, 2, "cd"},
+		{[]int{1, 1, 0, 1, 0, 0, 1, 0}, 2, 4, "d2"},
+		{[]int{1, 0, 1, 0, 1, 0, 1, 1, 1, 1, 0, 0, 1, 1, 0, 1}, 4, 4, "abcd"},
+		{[]int{1, 1, 0, 1, 1, 1, 1, 1, 1, 1, 0, 1, 1, 1, 1, 1}, 4, 4, "dfdf"},
+		{[]int{1, 0, 1, 1, 1, 1, 1, 1, 1, 1, 1, 1, 1, 1, 1, 1, 1}, 17, 1, "bfff8"},
+		{[]int{0, 0, 0, 1, 0, 0, 1, 0, 0, 1, 0, 0, 1, 0, 0, 0, 1, 0, 1, 0, 1, 0, 1, 1, 1, 1, 0, 0, 1, 1, 0, 1}, 8, 4, "1248abcd"},
+	}
+
+	for _, c := range cases {
+		actual := newDigest(0, c.bitString, c.columns, c.rows).String()
+		if actual != c.expected {
+			t.Fatalf("bits not converted to hex properly: [%s] != [%s]", actual, c.expected)
+		}
+	}
+}
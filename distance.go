@@ -0,0 +1,115 @@
+package blockhash
+
+import (
+	"errors"
+	"math/bits"
+	"strconv"
+
+	"github.com/dsoprea/go-logging"
+)
+
+var (
+	// ErrDigestSizeMismatch indicates that two digests were not produced with
+	// the same `hashbits` setting and can not be compared.
+	ErrDigestSizeMismatch = errors.New("digests have different sizes")
+)
+
+// Distance returns the number of bits that differ between two hex-digests
+// (the Hamming distance).
+func Distance(hexdigest1, hexdigest2 string) (distance int, err error) {
+	defer func() {
+		if state := recover(); state != nil {
+			err = log.Wrap(state.(error))
+		}
+	}()
+
+	// Both digests are zero-padded to the full width implied by `hashbits`,
+	// so different lengths mean different settings.
+	if len(hexdigest1) != len(hexdigest2) {
+		return 0, ErrDigestSizeMismatch
+	}
+
+	// 8x8 digests are compared as integers in one step.
+	if len(hexdigest1) == 16 {
+		d1, err := ParseDigest64(hexdigest1)
+		log.PanicIf(err)
+
+		d2, err := ParseDigest64(hexdigest2)
+		log.PanicIf(err)
+
+		return d1.Distance(d2), nil
+	}
+
+	for i := 0; i < len(hexdigest1); i++ {
+		n1, err := strconv.ParseUint(hexdigest1[i:i+1], 16, 8)
+		log.PanicIf(err)
+
+		n2, err := strconv.ParseUint(hexdigest2[i:i+1], 16, 8)
+		log.PanicIf(err)
+
+		distance += bits.OnesCount8(uint8(n1 ^ n2))
+	}
+
+	return distance, nil
+}
+
+// Similarity returns the fraction of bits that are equal between two
+// hex-digests: 1.0 for identical digests and 0.0 for complementary ones. A
+// hex-digest doesn't record its grid, so every digit is counted as four bits.
+// This is only exact if the number of bits is a multiple of four; use
+// GridSimilarity() for other grids.
+func Similarity(hexdigest1, hexdigest2 string) (similarity float64, err error) {
+	distance, err := Distance(hexdigest1, hexdigest2)
+	if err != nil {
+		return 0.0, err
+	}
+
+	bitCount := len(hexdigest1) * 4
+	if bitCount == 0 {
+		return 1.0, nil
+	}
+
+	similarity = 1.0 - float64(distance)/float64(bitCount)
+
+	return similarity, nil
+}
+
+// Matches returns true if the two hex-digests differ by no more than
+// `maxDistance` bits.
+func Matches(hexdigest1, hexdigest2 string, maxDistance int) (matches bool, err error) {
+	distance, err := Distance(hexdigest1, hexdigest2)
+	if err != nil {
+		return false, err
+	}
+
+	return distance <= maxDistance, nil
+}
+
+// GridSimilarity is Similarity() for hex-digests of a grid with the given
+// number of columns and rows. It doesn't count the padding bits in the last
+// digit, so it is exact for any grid. ErrInvalidDigest is returned if a
+// hex-digest isn't for that grid.
+func GridSimilarity(hexdigest1, hexdigest2 string, columns, rows int) (similarity float64, err error) {
+	if len(hexdigest1) != len(hexdigest2) {
+		return 0.0, ErrDigestSizeMismatch
+	}
+
+	digest1, err := ParseGridDigest(hexdigest1, columns, rows)
+	if err != nil {
+		return 0.0, err
+	}
+
+	digest2, err := ParseGridDigest(hexdigest2, columns, rows)
+	if err != nil {
+		return 0.0, err
+	}
+
+	distance, err := digest1.Distance(digest2)
+	if err != nil {
+		return 0.0, err
+	}
+
+	similarity = 1.0 - float64(distance)/float64(digest1.Len())
+
+	return similarity, nil
+}
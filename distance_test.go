@@ -0,0 +1,151 @@
+package blockhash
+
+import (
+	"testing"
+
+	"github.com/dsoprea/go-logging"
+)
+
+func TestDistance__Identical(t *testing.T) {
+	hexdigest := "1ffc3fff00fe000031ff3e3f0f8007c03fff1f8d0f9806003ffc3ff80f0400f0"
+
+	distance, err := Distance(hexdigest, hexdigest)
+	log.PanicIf(err)
+
+	if distance != 0 {
+		t.Fatalf("distance of identical digests not zero: (%d)", distance)
+	}
+}
+
+func TestDistance__Different(t *testing.T) {
+	distance, err := Distance("0f00", "f0f1")
+	log.PanicIf(err)
+
+	if distance != 13 {
+		t.Fatalf("distance not correct: (%d)", distance)
+	}
+}
+
+func TestDistance__Images(t *testing.T) {
+	f1, bh1 := getTestBh(testImagePng1Small)
+	defer f1.Close()
+
+	f2, bh2 := getTestBh(testImagePng1SmallAlpha)
+	defer f2.Close()
+
+	distance, err := Distance(bh1.Hexdigest(), bh2.Hexdigest())
+	log.PanicIf(err)
+
+	if distance == 0 || distance > 64 {
+		t.Fatalf("distance between similar images not plausible: (%d)", distance)
+	}
+}
+
+func TestDistance__SizeMismatch(t *testing.T) {
+	_, err := Distance("0f00", "0f000000")
+	if err != ErrDigestSizeMismatch {
+		t.Fatalf("expected size-mismatch error: %v", err)
+	}
+}
+
+func TestDistance__InvalidHex(t *testing.T) {
+	_, err := Distance("0f0z", "0f00")
+	if err == nil {
+		t.Fatalf("expected error for invalid hex")
+	}
+}
+
+func TestSimilarity(t *testing.T) {
+	similarity, err := Similarity("0f00", "0f00")
+	log.PanicIf(err)
+
+	if similarity != 1.0 {
+		t.Fatalf("similarity of identical digests not correct: (%f)", similarity)
+	}
+
+	similarity, err = Similarity("0000", "ffff")
+	log.PanicIf(err)
+
+	if similarity != 0.0 {
+		t.Fatalf("similarity of complementary digests not correct: (%f)", similarity)
+	}
+
+	similarity, err = Similarity("0000", "00ff")
+	log.PanicIf(err)
+
+	if similarity != 0.5 {
+		t.Fatalf("similarity of half-different digests not correct: (%f)", similarity)
+	}
+}
+
+func TestSimilarity__SizeMismatch(t *testing.T) {
+	_, err := Similarity("0f00", "0f")
+	if err != ErrDigestSizeMismatch {
+		t.Fatalf("expected size-mismatch error: %v", err)
+	}
+}
+
+func TestGridSimilarity__Unaligned(t *testing.T) {
+	// A 1x1 grid has a single bit, which differs.
+
+	similarity, err := GridSimilarity("8", "0", 1, 1)
+	log.PanicIf(err)
+
+	if similarity != 0.0 {
+		t.Fatalf("similarity of a 1x1 grid not correct: (%f)", similarity)
+	}
+
+	// A 5x5 grid has 25 bits, of which these differ by five.
+
+	similarity, err = GridSimilarity("0000000", "f800000", 5, 5)
+	log.PanicIf(err)
+
+	if similarity != 0.8 {
+		t.Fatalf("similarity of a 5x5 grid not correct: (%f)", similarity)
+	}
+
+	// Without the grid, the padding counts as equal bits.
+
+	similarity, err = Similarity("8", "0")
+	log.PanicIf(err)
+
+	if similarity != 0.75 {
+		t.Fatalf("hex similarity not correct: (%f)", similarity)
+	}
+}
+
+func TestGridSimilarity__Invalid(t *testing.T) {
+	_, err := GridSimilarity("8", "00", 1, 1)
+	if err != ErrDigestSizeMismatch {
+		t.Fatalf("expected size-mismatch error: %v", err)
+	}
+
+	// The padding bits have to be zero.
+	_, err = GridSimilarity("8", "1", 1, 1)
+	if err != ErrInvalidDigest {
+		t.Fatalf("expected invalid-digest error: %v", err)
+	}
+}
+
+func TestMatches(t *testing.T) {
+	matches, err := Matches("0f00", "0f03", 2)
+	log.PanicIf(err)
+
+	if matches != true {
+		t.Fatalf("expected match at the maximum distance")
+	}
+
+	matches, err = Matches("0f00", "0f07", 2)
+	log.PanicIf(err)
+
+	if matches != false {
+		t.Fatalf("expected no match beyond the maximum distance")
+	}
+}
+
+func TestMatches__SizeMismatch(t *testing.T) {
+	_, err := Matches("0f00", "0f0000", 2)
+	if err != ErrDigestSizeMismatch {
+		t.Fatalf("expected size-mismatch error: %v", err)
+	}
+}
@@ -0,0 +1,226 @@
+package blockhash
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+)
+
+const (
+	// digestEncodingVersion is the version of the binary encoding written by
+	// MarshalBinary().
+	digestEncodingVersion = 1
+
+	// digestHeaderSize is the size of the binary header: the version, the
+	// algorithm, and the number of columns and rows.
+	digestHeaderSize = 6
+)
+
+// MarshalBinary encodes the digest as a six-byte header followed by the
+// packed bits (see Bytes()). The header is the encoding version, the
+// algorithm, and the number of columns and rows as big-endian uint16s, so the
+// exact number of bits is known and digests from different algorithms or
+// grids can be told apart. A 256-bit digest takes 38 bytes compared to 64 for
+// the hex-digest.
+func (d Digest) MarshalBinary() (data []byte, err error) {
+	if d.valid() == false || d.columns > math.MaxUint16 || d.rows > math.MaxUint16 {
+		return nil, ErrInvalidDigest
+	}
+
+	packed := d.Bytes()
+
+	data = make([]byte, digestHeaderSize+len(packed))
+
+	data[0] = digestEncodingVersion
+	data[1] = byte(d.algorithm)
+	binary.BigEndian.PutUint16(data[2:4], uint16(d.columns))
+	binary.BigEndian.PutUint16(data[4:6], uint16(d.rows))
+
+	copy(data[digestHeaderSize:], packed)
+
+	return data, nil
+}
+
+// UnmarshalBinary decodes a digest encoded by MarshalBinary(). ErrInvalidDigest
+// is returned if the data is malformed or from an unsupported version.
+func (d *Digest) UnmarshalBinary(data []byte) (err error) {
+	if len(data) < digestHeaderSize || data[0] != digestEncodingVersion {
+		return ErrInvalidDigest
+	}
+
+	algorithm := Algorithm(data[1])
+	columns := int(binary.BigEndian.Uint16(data[2:4]))
+	rows := int(binary.BigEndian.Uint16(data[4:6]))
+
+	decoded, err := DigestFromBytes(algorithm, data[digestHeaderSize:], columns, rows)
+	if err != nil {
+		return err
+	}
+
+	*d = decoded
+
+	return nil
+}
+
+// MarshalText encodes the digest as "algorithm:COLUMNSxROWS:hexdigest" (e.g.
+// "phash:8x8:b593c0690001ffff"). The algorithm is left out if it isn't known.
+func (d Digest) MarshalText() (text []byte, err error) {
+	if d.valid() == false {
+		return nil, ErrInvalidDigest
+	}
+
+	encoded := fmt.Sprintf("%dx%d:%s", d.columns, d.rows, d.String())
+
+	if d.algorithm != 0 {
+		encoded = d.algorithm.String() + ":" + encoded
+	}
+
+	return []byte(encoded), nil
+}
+
+// UnmarshalText decodes a digest encoded by MarshalText().
+func (d *Digest) UnmarshalText(text []byte) (err error) {
+	parts := strings.Split(string(text), ":")
+
+	var algorithm Algorithm
+	if len(parts) == 3 {
+		algorithm, err = ParseAlgorithm(parts[0])
+		if err != nil {
+			return err
+		}
+
+		parts = parts[1:]
+	} else if len(parts) != 2 {
+		return ErrInvalidDigest
+	}
+
+	columns, rows, err := parseGridSize(parts[0])
+	if err != nil {
+		return err
+	}
+
+	parsed, err := ParseGridDigest(parts[1], columns, rows)
+	if err != nil {
+		return err
+	}
+
+	parsed.algorithm = algorithm
+	*d = parsed
+
+	return nil
+}
+
+// digestJson is the object form of a digest in JSON.
+type digestJson struct {
+	Algorithm string `json:"algorithm,omitempty"`
+	Bits      int    `json:"bits"`
+	Columns   int    `json:"columns"`
+	Rows      int    `json:"rows"`
+	Hex       string `json:"hex"`
+}
+
+// MarshalJSON encodes the digest as a self-describing object, such as
+// {"algorithm":"phash","bits":64,"columns":8,"rows":8,"hex":"b593c0690001ffff"}.
+// The algorithm is left out if it isn't known. The zero Digest is encoded as
+// null. Use TextDigest for the compact string form.
+func (d Digest) MarshalJSON() (data []byte, err error) {
+	if d.isZero() == true {
+		return []byte("null"), nil
+	} else if d.valid() == false {
+		return nil, ErrInvalidDigest
+	}
+
+	dj := digestJson{
+		Bits:    d.Len(),
+		Columns: d.columns,
+		Rows:    d.rows,
+		Hex:     d.String(),
+	}
+
+	if d.algorithm != 0 {
+		dj.Algorithm = d.algorithm.String()
+	}
+
+	return json.Marshal(dj)
+}
+
+// UnmarshalJSON decodes either the object form from MarshalJSON() or the
+// string form from TextDigest. JSON null is ignored.
+func (d *Digest) UnmarshalJSON(data []byte) (err error) {
+	data = bytes.TrimSpace(data)
+
+	if bytes.Equal(data, []byte("null")) == true {
+		return nil
+	}
+
+	if len(data) > 0 && data[0] == '"' {
+		var text string
+
+		err := json.Unmarshal(data, &text)
+		if err != nil {
+			return ErrInvalidDigest
+		}
+
+		return d.UnmarshalText([]byte(text))
+	}
+
+	dj := digestJson{}
+
+	err = json.Unmarshal(data, &dj)
+	if err != nil {
+		return ErrInvalidDigest
+	}
+
+	var algorithm Algorithm
+	if dj.Algorithm != "" {
+		algorithm, err = ParseAlgorithm(dj.Algorithm)
+		if err != nil {
+			return err
+		}
+	}
+
+	parsed, err := ParseGridDigest(dj.Hex, dj.Columns, dj.Rows)
+	if err != nil {
+		return err
+	}
+
+	// The bit count is redundant, but if it's there it has to agree.
+	if dj.Bits != 0 && dj.Bits != parsed.Len() {
+		return ErrInvalidDigest
+	}
+
+	parsed.algorithm = algorithm
+	*d = parsed
+
+	return nil
+}
+
+// valid returns true if the digest has a grid and the bits to fill it. The
+// zero Digest isn't valid.
+func (d Digest) valid() bool {
+	return d.columns > 0 && d.rows > 0 && len(d.words) == wordCount(d.columns*d.rows)
+}
+
+// parseGridSize parses a grid size like "16x8".
+func parseGridSize(s string) (columns, rows int, err error) {
+	parts := strings.Split(s, "x")
+	if len(parts) != 2 {
+		return 0, 0, ErrInvalidDigest
+	}
+
+	columns, err = strconv.Atoi(parts[0])
+	if err != nil || columns <= 0 {
+		return 0, 0, ErrInvalidDigest
+	}
+
+	rows, err = strconv.Atoi(parts[1])
+	if err != nil || rows <= 0 {
+		return 0, 0, ErrInvalidDigest
+	}
+
+	return columns, rows, nil
+}
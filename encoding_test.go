@@ -0,0 +1,399 @@
+package blockhash
+
+import (
+	"bytes"
+	"encoding"
+	"encoding/json"
+	"testing"
+
+	"github.com/dsoprea/go-logging"
+)
+
+// Make sure that digests satisfy the standard encoding interfaces.
+var (
+	_ encoding.BinaryMarshaler   = Digest{}
+	_ encoding.BinaryUnmarshaler = new(Digest)
+	_ encoding.TextMarshaler     = Digest{}
+	_ encoding.TextUnmarshaler   = new(Digest)
+	_ json.Marshaler             = Digest{}
+	_ json.Unmarshaler           = new(Digest)
+	_ json.Marshaler             = TextDigest{}
+)
+
+func getTestDigests() []Digest {
+	f, i := getTestImage(testImagePng1Small)
+	defer f.Close()
+
+	digests := make([]Digest, 0)
+
+	for _, algorithm := range Algorithms() {
+		for _, opts := range [][]Option{nil, {WithGridSize(5, 3), WithLevel(2)}} {
+			hasher, err := NewHasher(algorithm, opts...)
+			log.PanicIf(err)
+
+			digest, err := hasher.Hash(i)
+			log.PanicIf(err)
+
+			digests = append(digests, digest)
+		}
+	}
+
+	return digests
+}
+
+func TestDigest_MarshalBinary(t *testing.T) {
+	digest, err := ParseDigest("0123456789abcdef", 8)
+	log.PanicIf(err)
+
+	data, err := digest.MarshalBinary()
+	log.PanicIf(err)
+
+	expected := []byte{
+		0x01, 0x00, 0x00, 0x08, 0x00, 0x08,
+		0x01, 0x23, 0x45, 0x67, 0x89, 0xab, 0xcd, 0xef,
+	}
+
+	if bytes.Equal(data, expected) != true {
+		t.Fatalf("encoding not correct: %x", data)
+	}
+
+	f, bh := getTestBh(testImagePng1Small)
+	defer f.Close()
+
+	computed, err := bh.Digest()
+	log.PanicIf(err)
+
+	data, err = computed.MarshalBinary()
+	log.PanicIf(err)
+
+	if len(data) != 38 {
+		t.Fatalf("encoding of 256-bit digest not the right size: (%d)", len(data))
+	} else if data[1] != byte(AlgorithmBlockhash) {
+		t.Fatalf("algorithm not encoded: (%d)", data[1])
+	}
+}
+
+func TestDigest_MarshalBinary__Invalid(t *testing.T) {
+	_, err := Digest{}.MarshalBinary()
+	if err != ErrInvalidDigest {
+		t.Fatalf("expected invalid-digest error: %v", err)
+	}
+}
+
+func TestDigest_UnmarshalBinary__RoundTrip(t *testing.T) {
+	for _, digest := range getTestDigests() {
+		data, err := digest.MarshalBinary()
+		log.PanicIf(err)
+
+		var decoded Digest
+
+		err = decoded.UnmarshalBinary(data)
+		log.PanicIf(err)
+
+		if decoded.Equal(digest) != true {
+			t.Fatalf("digest did not round-trip: [%s] != [%s]", decoded, digest)
+		} else if decoded.Algorithm() != digest.Algorithm() {
+			t.Fatalf("algorithm did not round-trip: [%s]", decoded.Algorithm())
+		} else if decoded.Columns() != digest.Columns() || decoded.Rows() != digest.Rows() {
+			t.Fatalf("grid did not round-trip: (%d) (%d)", decoded.Columns(), decoded.Rows())
+		}
+	}
+}
+
+func TestDigest_UnmarshalBinary__Invalid(t *testing.T) {
+	valid := []byte{0x01, 0x03, 0x00, 0x03, 0x00, 0x03, 0xb3, 0x80}
+
+	var digest Digest
+
+	err := digest.UnmarshalBinary(valid)
+	log.PanicIf(err)
+
+	if digest.String() != "b38" || digest.Algorithm() != AlgorithmPHash {
+		t.Fatalf("digest not correct: [%s] [%s]", digest, digest.Algorithm())
+	}
+
+	cases := map[string][]byte{
+		"short header":    valid[:5],
+		"version":         {0x02, 0x03, 0x00, 0x03, 0x00, 0x03, 0xb3, 0x80},
+		"algorithm":       {0x01, 0x63, 0x00, 0x03, 0x00, 0x03, 0xb3, 0x80},
+		"no columns":      {0x01, 0x03, 0x00, 0x00, 0x00, 0x03, 0xb3, 0x80},
+		"too few bytes":   valid[:7],
+		"too many bytes":  append(append([]byte{}, valid...), 0x00),
+		"padding not set": {0x01, 0x03, 0x00, 0x03, 0x00, 0x03, 0xb3, 0x81},
+	}
+
+	for name, data := range cases {
+		err := digest.UnmarshalBinary(data)
+		if err != ErrInvalidDigest {
+			t.Fatalf("%s: expected invalid-digest error: %v", name, err)
+		}
+	}
+}
+
+func TestDigest_MarshalText(t *testing.T) {
+	digest, err := ParseDigest("0123456789abcdef", 8)
+	log.PanicIf(err)
+
+	text, err := digest.MarshalText()
+	log.PanicIf(err)
+
+	if string(text) != "8x8:0123456789abcdef" {
+		t.Fatalf("encoding not correct: [%s]", text)
+	}
+
+	f, bh := getTestBh(testImagePng1Small)
+	defer f.Close()
+
+	computed, err := bh.Digest()
+	log.PanicIf(err)
+
+	text, err = computed.MarshalText()
+	log.PanicIf(err)
+
+	if string(text) != "blockhash:16x16:"+testDigestSmall {
+		t.Fatalf("encoding not correct: [%s]", text)
+	}
+}
+
+func TestDigest_UnmarshalText__RoundTrip(t *testing.T) {
+	digests := getTestDigests()
+
+	unknown, err := ParseDigest("0123456789abcdef", 8)
+	log.PanicIf(err)
+
+	digests = append(digests, unknown)
+
+	for _, digest := range digests {
+		text, err := digest.MarshalText()
+		log.PanicIf(err)
+
+		var decoded Digest
+
+		err = decoded.UnmarshalText(text)
+		log.PanicIf(err)
+
+		if decoded.Equal(digest) != true || decoded.Algorithm() != digest.Algorithm() {
+			t.Fatalf("digest did not round-trip: [%s]", text)
+		}
+	}
+}
+
+func TestDigest_UnmarshalText__Invalid(t *testing.T) {
+	var digest Digest
+
+	cases := []string{
+		"",
+		"0123456789abcdef",
+		"8x8",
+		"8:0123456789abcdef",
+		"8x0:0123456789abcdef",
+		"8x8x8:0123456789abcdef",
+		"4x4:0123456789abcdef",
+		"a:b:c:d",
+	}
+
+	for _, text := range cases {
+		err := digest.UnmarshalText([]byte(text))
+		if err != ErrInvalidDigest {
+			t.Fatalf("expected invalid-digest error for [%s]: %v", text, err)
+		}
+	}
+
+	err := digest.UnmarshalText([]byte("md5:8x8:0123456789abcdef"))
+	if err != ErrInvalidAlgorithm {
+		t.Fatalf("expected invalid-algorithm error: %v", err)
+	}
+}
+
+func TestDigest_Distance__AlgorithmMismatch(t *testing.T) {
+	f, i := getTestImage(testImagePng1Small)
+	defer f.Close()
+
+	ph, err := NewPHash()
+	log.PanicIf(err)
+
+	ah, err := NewAHash()
+	log.PanicIf(err)
+
+	phDigest, err := ph.Hash(i)
+	log.PanicIf(err)
+
+	ahDigest, err := ah.Hash(i)
+	log.PanicIf(err)
+
+	_, err = phDigest.Distance(ahDigest)
+	if err != ErrAlgorithmMismatch {
+		t.Fatalf("expected algorithm-mismatch error: %v", err)
+	} else if phDigest.Equal(ahDigest) == true {
+		t.Fatalf("digests from different algorithms should not be equal")
+	}
+
+	// A digest without an algorithm can be compared to anything.
+
+	parsed, err := ParseDigest(phDigest.String(), 8)
+	log.PanicIf(err)
+
+	distance, err := parsed.Distance(phDigest)
+	log.PanicIf(err)
+
+	if distance != 0 || parsed.Equal(phDigest) != true {
+		t.Fatalf("parsed digest should match: (%d)", distance)
+	}
+}
+
+func TestDigest_MarshalJSON(t *testing.T) {
+	f, i := getTestImage(testImagePng1Small)
+	defer f.Close()
+
+	ph, err := NewPHash()
+	log.PanicIf(err)
+
+	digest, err := ph.Hash(i)
+	log.PanicIf(err)
+
+	data, err := json.Marshal(digest)
+	log.PanicIf(err)
+
+	expected := `{"algorithm":"phash","bits":64,"columns":8,"rows":8,"hex":"` + digest.String() + `"}`
+	if string(data) != expected {
+		t.Fatalf("object form not correct: [%s]", data)
+	}
+
+	data, err = json.Marshal(TextDigest{digest})
+	log.PanicIf(err)
+
+	expected = `"phash:8x8:` + digest.String() + `"`
+	if string(data) != expected {
+		t.Fatalf("string form not correct: [%s]", data)
+	}
+
+	// The algorithm is left out if it isn't known.
+
+	parsed, err := ParseDigest("0123456789abcdef", 8)
+	log.PanicIf(err)
+
+	data, err = json.Marshal(parsed)
+	log.PanicIf(err)
+
+	if string(data) != `{"bits":64,"columns":8,"rows":8,"hex":"0123456789abcdef"}` {
+		t.Fatalf("object form without algorithm not correct: [%s]", data)
+	}
+
+	// The zero digest is null, in both forms.
+
+	data, err = json.Marshal(Digest{})
+	log.PanicIf(err)
+
+	if string(data) != "null" {
+		t.Fatalf("zero digest not correct: [%s]", data)
+	}
+
+	data, err = json.Marshal(TextDigest{})
+	log.PanicIf(err)
+
+	if string(data) != "null" {
+		t.Fatalf("zero digest in string form not correct: [%s]", data)
+	}
+}
+
+func TestDigest_MarshalJSON__Unset(t *testing.T) {
+	type record struct {
+		Path    string     `json:"path"`
+		Digest  Digest     `json:"digest"`
+		Compact TextDigest `json:"compact,omitempty"`
+	}
+
+	data, err := json.Marshal(record{Path: "image.png"})
+	log.PanicIf(err)
+
+	if string(data) != `{"path":"image.png","digest":null,"compact":null}` {
+		t.Fatalf("record not correct: [%s]", data)
+	}
+
+	var recovered record
+
+	err = json.Unmarshal(data, &recovered)
+	log.PanicIf(err)
+
+	if recovered.Path != "image.png" || recovered.Digest.isZero() != true || recovered.Compact.isZero() != true {
+		t.Fatalf("record did not round-trip: %v", recovered)
+	}
+}
+
+func TestDigest_UnmarshalJSON__RoundTrip(t *testing.T) {
+	type record struct {
+		Path    string     `json:"path"`
+		Digest  Digest     `json:"digest"`
+		Compact TextDigest `json:"compact"`
+	}
+
+	for _, digest := range getTestDigests() {
+		original := record{
+			Path:    "image.png",
+			Digest:  digest,
+			Compact: TextDigest{digest},
+		}
+
+		data, err := json.Marshal(original)
+		log.PanicIf(err)
+
+		var decoded record
+
+		err = json.Unmarshal(data, &decoded)
+		log.PanicIf(err)
+
+		if decoded.Digest.Equal(digest) != true || decoded.Digest.Algorithm() != digest.Algorithm() {
+			t.Fatalf("%s: object form did not round-trip: [%s]", digest.Algorithm(), data)
+		} else if decoded.Compact.Equal(digest) != true || decoded.Compact.Algorithm() != digest.Algorithm() {
+			t.Fatalf("%s: string form did not round-trip: [%s]", digest.Algorithm(), data)
+		} else if decoded.Digest.Columns() != digest.Columns() || decoded.Digest.Rows() != digest.Rows() {
+			t.Fatalf("%s: grid did not round-trip: [%s]", digest.Algorithm(), data)
+		}
+
+		// Either form can be read into a Digest.
+
+		compact, err := json.Marshal(TextDigest{digest})
+		log.PanicIf(err)
+
+		var fromString Digest
+
+		err = json.Unmarshal(compact, &fromString)
+		log.PanicIf(err)
+
+		if fromString.Equal(digest) != true {
+			t.Fatalf("%s: string form not read into digest: [%s]", digest.Algorithm(), compact)
+		}
+	}
+}
+
+func TestDigest_UnmarshalJSON__Invalid(t *testing.T) {
+	cases := []string{
+		`{"bits":64,"columns":8,"rows":8,"hex":"0123"}`,
+		`{"bits":63,"columns":8,"rows":8,"hex":"0123456789abcdef"}`,
+		`{"columns":0,"rows":8,"hex":"0123456789abcdef"}`,
+		`"8x8"`,
+		`12`,
+		`[]`,
+	}
+
+	for _, data := range cases {
+		var digest Digest
+
+		err := json.Unmarshal([]byte(data), &digest)
+		if log.Is(err, ErrInvalidDigest) != true {
+			t.Fatalf("expected invalid-digest error for [%s]: %v", data, err)
+		}
+	}
+
+	var digest Digest
+
+	err := json.Unmarshal([]byte(`{"algorithm":"md5","columns":8,"rows":8,"hex":"0123456789abcdef"}`), &digest)
+	if log.Is(err, ErrInvalidAlgorithm) != true {
+		t.Fatalf("expected invalid-algorithm error: %v", err)
+	}
+
+	// Null leaves the digest alone.
+
+	err = json.Unmarshal([]byte(`null`), &digest)
+	log.PanicIf(err)
+}
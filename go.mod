@@ -1,3 +1,10 @@
 module github.com/dsoprea/go-perceptualhash
 
 go 1.13
+
+require (
+	github.com/dsoprea/go-logging v0.0.0-20200710184922-b02d349568dd
+	github.com/jessevdk/go-flags v1.6.1
+	github.com/nfnt/resize v0.0.0-20180221191011-83c6a9932646
+	golang.org/x/image v0.18.0
+)
@@ -0,0 +1,140 @@
+package blockhash
+
+import (
+	"context"
+	"errors"
+	"image"
+)
+
+var (
+	// ErrInvalidAlgorithm indicates that the algorithm is not known.
+	ErrInvalidAlgorithm = errors.New("invalid algorithm")
+)
+
+// Hasher calculates digests for images. All of the algorithms implement it.
+// Hashers only hold their configuration, so one can be shared by any number
+// of goroutines.
+type Hasher interface {
+	// Hash calculates the digest of the given image.
+	Hash(img image.Image) (digest Digest, err error)
+}
+
+// ContextHasher is a Hasher that can be canceled while it is reading an
+// image, which can take a while for very large images. All of the algorithms
+// implement it.
+type ContextHasher interface {
+	Hasher
+
+	// HashContext calculates the digest of the given image. The context's
+	// error is returned if it is canceled before the digest is finished.
+	HashContext(ctx context.Context, img image.Image) (digest Digest, err error)
+}
+
+// Algorithm identifies a hash algorithm.
+type Algorithm int
+
+const (
+	// AlgorithmBlockhash is the blockhash.io algorithm (Blockhash).
+	AlgorithmBlockhash Algorithm = iota + 1
+
+	// AlgorithmDHash is the difference hash (DHash).
+	AlgorithmDHash
+
+	// AlgorithmPHash is the DCT-based perceptual hash (PHash).
+	AlgorithmPHash
+
+	// AlgorithmAHash is the average hash (AHash).
+	AlgorithmAHash
+
+	// AlgorithmWHash is the Haar wavelet hash (WHash).
+	AlgorithmWHash
+)
+
+var (
+	algorithmNames = map[Algorithm]string{
+		AlgorithmBlockhash: "blockhash",
+		AlgorithmDHash:     "dhash",
+		AlgorithmPHash:     "phash",
+		AlgorithmAHash:     "ahash",
+		AlgorithmWHash:     "whash",
+	}
+)
+
+// Algorithms returns all of the supported algorithms.
+func Algorithms() []Algorithm {
+	return []Algorithm{
+		AlgorithmBlockhash,
+		AlgorithmDHash,
+		AlgorithmPHash,
+		AlgorithmAHash,
+		AlgorithmWHash,
+	}
+}
+
+// String returns the name of the algorithm.
+func (algorithm Algorithm) String() string {
+	name, found := algorithmNames[algorithm]
+	if found == false {
+		return "unknown"
+	}
+
+	return name
+}
+
+// ParseAlgorithm returns the algorithm with the given name (e.g. "blockhash").
+func ParseAlgorithm(name string) (algorithm Algorithm, err error) {
+	for algorithm, algorithmName := range algorithmNames {
+		if algorithmName == name {
+			return algorithm, nil
+		}
+	}
+
+	return 0, ErrInvalidAlgorithm
+}
+
+// NewHasher returns a Hasher for the given algorithm. Each algorithm applies
+// its own defaults for any options that aren't given.
+func NewHasher(algorithm Algorithm, opts ...Option) (hasher Hasher, err error) {
+	// Each case is careful not to return a typed nil pointer inside of the
+	// interface.
+
+	switch algorithm {
+	case AlgorithmBlockhash:
+		bh, err := NewBlockhashHasher(opts...)
+		if err != nil {
+			return nil, err
+		}
+
+		return bh, nil
+	case AlgorithmDHash:
+		dh, err := NewDHash(opts...)
+		if err != nil {
+			return nil, err
+		}
+
+		return dh, nil
+	case AlgorithmPHash:
+		ph, err := NewPHash(opts...)
+		if err != nil {
+			return nil, err
+		}
+
+		return ph, nil
+	case AlgorithmAHash:
+		ah, err := NewAHash(opts...)
+		if err != nil {
+			return nil, err
+		}
+
+		return ah, nil
+	case AlgorithmWHash:
+		wh, err := NewWHash(opts...)
+		if err != nil {
+			return nil, err
+		}
+
+		return wh, nil
+	}
+
+	return nil, ErrInvalidAlgorithm
+}
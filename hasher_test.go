@@ -0,0 +1,368 @@
+package blockhash
+
+import (
+	"context"
+	"image"
+	"image/color"
+	"sync"
+	"testing"
+
+	"github.com/dsoprea/go-logging"
+)
+
+// Make sure that every algorithm satisfies the interface.
+var (
+	_ Hasher = new(Blockhash)
+	_ Hasher = new(DHash)
+	_ Hasher = new(PHash)
+	_ Hasher = new(AHash)
+	_ Hasher = new(WHash)
+
+	_ ContextHasher = new(Blockhash)
+	_ ContextHasher = new(DHash)
+	_ ContextHasher = new(PHash)
+	_ ContextHasher = new(AHash)
+	_ ContextHasher = new(WHash)
+)
+
+// cancelingImage cancels a context once a given row has been read through the
+// general `At()` path.
+type cancelingImage struct {
+	image.Image
+
+	cancelAtY int
+	cancel    context.CancelFunc
+}
+
+func (ci cancelingImage) At(x, y int) color.Color {
+	if y == ci.cancelAtY {
+		ci.cancel()
+	}
+
+	return ci.Image.At(x, y)
+}
+
+func TestAlgorithm_String(t *testing.T) {
+	if AlgorithmPHash.String() != "phash" {
+		t.Fatalf("name not correct: [%s]", AlgorithmPHash.String())
+	} else if Algorithm(99).String() != "unknown" {
+		t.Fatalf("name of invalid algorithm not correct: [%s]", Algorithm(99).String())
+	}
+}
+
+func TestParseAlgorithm(t *testing.T) {
+	for _, algorithm := range Algorithms() {
+		parsed, err := ParseAlgorithm(algorithm.String())
+		log.PanicIf(err)
+
+		if parsed != algorithm {
+			t.Fatalf("algorithm did not round-trip: [%s] != [%s]", parsed, algorithm)
+		}
+	}
+
+	_, err := ParseAlgorithm("md5")
+	if err != ErrInvalidAlgorithm {
+		t.Fatalf("expected invalid-algorithm error: %v", err)
+	}
+}
+
+func TestNewHasher(t *testing.T) {
+	f, i := getTestImage(testImagePng1Small)
+	defer f.Close()
+
+	for _, algorithm := range Algorithms() {
+		hasher, err := NewHasher(algorithm)
+		log.PanicIf(err)
+
+		digest, err := hasher.Hash(i)
+		log.PanicIf(err)
+
+		if digest.Len() == 0 {
+			t.Fatalf("%s: digest is empty", algorithm)
+		}
+	}
+}
+
+func TestNewHasher__WithGridSize(t *testing.T) {
+	f, i := getTestImage(testImagePng1Small)
+	defer f.Close()
+
+	for _, algorithm := range Algorithms() {
+		hasher, err := NewHasher(algorithm, WithGridSize(8, 4), WithLevel(2))
+		log.PanicIf(err)
+
+		digest, err := hasher.Hash(i)
+		log.PanicIf(err)
+
+		if digest.Columns() != 8 || digest.Rows() != 4 || digest.Len() != 32 {
+			t.Fatalf("%s: digest not the right size: (%d) (%d) (%d)", algorithm, digest.Columns(), digest.Rows(), digest.Len())
+		} else if len(digest.String()) != 8 {
+			t.Fatalf("%s: hex-digest not the right size: [%s]", algorithm, digest)
+		}
+	}
+}
+
+func TestNewHasher__UnalignedHashbits(t *testing.T) {
+	f, i := getTestImage(testImagePng1Small)
+	defer f.Close()
+
+	for _, algorithm := range Algorithms() {
+		hasher, err := NewHasher(algorithm, WithGridSize(5, 3), WithLevel(2))
+		log.PanicIf(err)
+
+		digest, err := hasher.Hash(i)
+		log.PanicIf(err)
+
+		if digest.Len() != 15 || len(digest.String()) != 4 {
+			t.Fatalf("%s: digest not the right size: (%d) [%s]", algorithm, digest.Len(), digest)
+		}
+	}
+}
+
+func TestNewHasher__InvalidOptions(t *testing.T) {
+	for _, algorithm := range Algorithms() {
+		hasher, err := NewHasher(algorithm, WithHashbits(0))
+		if err != ErrInvalidHashbits {
+			t.Fatalf("%s: expected invalid-hashbits error: %v", algorithm, err)
+		} else if hasher != nil {
+			t.Fatalf("%s: hasher not nil on error", algorithm)
+		}
+	}
+}
+
+func TestNewHasher__InvalidAlgorithm(t *testing.T) {
+	_, err := NewHasher(Algorithm(99))
+	if err != ErrInvalidAlgorithm {
+		t.Fatalf("expected invalid-algorithm error: %v", err)
+	}
+}
+
+func TestBlockhash_Hash(t *testing.T) {
+	f, i := getTestImage(testImagePng1Small)
+	defer f.Close()
+
+	hasher, err := NewHasher(AlgorithmBlockhash)
+	log.PanicIf(err)
+
+	digest, err := hasher.Hash(i)
+	log.PanicIf(err)
+
+	if digest.String() != testDigestSmall {
+		t.Fatalf("digest not correct: [%s]", digest)
+	}
+
+	// The same hasher can be reused for other images.
+
+	f2, i2 := getTestImage(testImagePng1SmallEven)
+	defer f2.Close()
+
+	digest2, err := hasher.Hash(i2)
+	log.PanicIf(err)
+
+	bh := NewBlockhash(i2, 16)
+	if digest2.String() != bh.Hexdigest() {
+		t.Fatalf("digest of second image not correct: [%s]", digest2)
+	}
+}
+
+func TestBlockhash_Hash__Unbound(t *testing.T) {
+	bh, err := NewBlockhashHasher()
+	log.PanicIf(err)
+
+	_, err = bh.Digest()
+	if err != ErrEmptyImage {
+		t.Fatalf("expected empty-image error for unbound hasher: %v", err)
+	}
+
+	_, err = bh.Hash(image.NewGray(image.Rect(0, 0, 4, 4)))
+	if err != ErrImageTooSmall {
+		t.Fatalf("expected image-too-small error: %v", err)
+	}
+}
+
+func TestHasher__Concurrent(t *testing.T) {
+	// Run with `-race` to check for data races.
+
+	filenames := []string{
+		testImagePng1Small,
+		testImagePng1SmallAlpha,
+		testImagePng1SmallEven,
+	}
+
+	images := make([]image.Image, len(filenames))
+	for i, filename := range filenames {
+		f, img := getTestImage(filename)
+		f.Close()
+
+		images[i] = img
+	}
+
+	for _, algorithm := range Algorithms() {
+		hasher, err := NewHasher(algorithm, WithHashbits(8), WithLevel(2))
+		log.PanicIf(err)
+
+		expected := make([]Digest, len(images))
+		for i, img := range images {
+			expected[i], err = hasher.Hash(img)
+			log.PanicIf(err)
+		}
+
+		wg := new(sync.WaitGroup)
+		errors := make(chan error, 32)
+
+		for n := 0; n < 32; n++ {
+			wg.Add(1)
+
+			go func(n int) {
+				defer wg.Done()
+
+				for j := 0; j < 10; j++ {
+					i := (n + j) % len(images)
+
+					digest, err := hasher.Hash(images[i])
+					if err != nil {
+						errors <- err
+						return
+					}
+
+					if digest.Equal(expected[i]) != true {
+						t.Errorf("%s: concurrent digest not correct: [%s] != [%s]", algorithm, digest, expected[i])
+						return
+					}
+				}
+			}(n)
+		}
+
+		wg.Wait()
+		close(errors)
+
+		for err := range errors {
+			log.PanicIf(err)
+		}
+	}
+}
+
+func TestBlockhash_Digest__Concurrent(t *testing.T) {
+	f, i := getTestImage(testImagePng1Small)
+	defer f.Close()
+
+	bh, err := New(i)
+	log.PanicIf(err)
+
+	wg := new(sync.WaitGroup)
+
+	for n := 0; n < 16; n++ {
+		wg.Add(1)
+
+		go func() {
+			defer wg.Done()
+
+			if bh.Hexdigest() != testDigestSmall {
+				t.Errorf("concurrent digest not correct")
+			}
+		}()
+	}
+
+	wg.Wait()
+}
+
+func BenchmarkHasher_Hash(b *testing.B) {
+	f, i := getTestImage(testImagePng1Small)
+	f.Close()
+
+	hasher, err := NewBlockhashHasher()
+	log.PanicIf(err)
+
+	b.ReportAllocs()
+	b.ResetTimer()
+
+	for n := 0; n < b.N; n++ {
+		_, err := hasher.Hash(i)
+		log.PanicIf(err)
+	}
+}
+
+func TestHasher_HashContext__Canceled(t *testing.T) {
+	f, i := getTestImage(testImagePng1Small)
+	defer f.Close()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	for _, algorithm := range Algorithms() {
+		for _, method := range []Method{MethodPrecise, MethodQuick} {
+			hasher, err := NewHasher(algorithm, WithHashbits(4), WithLevel(1), WithMethod(method))
+			log.PanicIf(err)
+
+			_, err = hasher.(ContextHasher).HashContext(ctx, i)
+			if err != context.Canceled {
+				t.Fatalf("%s: expected cancellation error: %v", algorithm, err)
+			}
+
+			// Without the context, the same hasher still works.
+
+			_, err = hasher.Hash(i)
+			log.PanicIf(err)
+		}
+	}
+}
+
+func TestHasher_HashContext__CanceledWhileReading(t *testing.T) {
+	big := image.NewGray(image.Rect(0, 0, 64, 1000))
+
+	for _, method := range []Method{MethodPrecise, MethodQuick} {
+		bh, err := NewBlockhashHasher(WithMethod(method))
+		log.PanicIf(err)
+
+		ctx, cancel := context.WithCancel(context.Background())
+
+		ci := cancelingImage{
+			Image:     big,
+			cancelAtY: 100,
+			cancel:    cancel,
+		}
+
+		_, err = bh.HashContext(ctx, ci)
+		if err != context.Canceled {
+			t.Fatalf("expected cancellation error: %v", err)
+		}
+	}
+}
+
+func TestHasher_HashContext__DeadlineExceeded(t *testing.T) {
+	f, i := getTestImage(testImagePng1Small)
+	defer f.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 0)
+	defer cancel()
+
+	ph, err := NewPHash()
+	log.PanicIf(err)
+
+	_, err = ph.HashContext(ctx, i)
+	if err != context.DeadlineExceeded {
+		t.Fatalf("expected deadline error: %v", err)
+	}
+}
+
+func TestBlockhash_DigestContext__Canceled(t *testing.T) {
+	f, bh := getTestBh(testImagePng1Small)
+	defer f.Close()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	_, err := bh.DigestContext(ctx)
+	if err != context.Canceled {
+		t.Fatalf("expected cancellation error: %v", err)
+	}
+
+	// The canceled attempt must not have been kept.
+
+	digest, err := bh.Digest()
+	log.PanicIf(err)
+
+	if digest.String() != testDigestSmall {
+		t.Fatalf("digest not correct after cancellation: [%s]", digest)
+	}
+}
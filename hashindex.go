@@ -0,0 +1,597 @@
+package blockhash
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"hash/crc32"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/dsoprea/go-logging"
+)
+
+const (
+	// hashIndexMagic starts every hash-index file.
+	hashIndexMagic = "BHIX"
+
+	// hashIndexVersion is the version of the file format.
+	hashIndexVersion = 1
+
+	// hashIndexHeaderSize is the size of the magic and version.
+	hashIndexHeaderSize = len(hashIndexMagic) + 1
+
+	// maxHashIndexRecordSize bounds the size of a record so that a corrupt
+	// length can't make us allocate an enormous buffer.
+	maxHashIndexRecordSize = 1 << 20
+)
+
+var (
+	// ErrInvalidHashIndex indicates that a file isn't a hash index or is from
+	// an unsupported version.
+	ErrInvalidHashIndex = errors.New("not a supported hash index")
+
+	// ErrCorruptHashIndex indicates that a record in a hash index is truncated
+	// or fails its checksum.
+	ErrCorruptHashIndex = errors.New("hash index is corrupt")
+)
+
+// HashIndexEntry is the digest of one file along with what the file looked
+// like when it was hashed.
+type HashIndexEntry struct {
+	// Path identifies the file. It is not interpreted.
+	Path string
+
+	// Size is the size of the file in bytes.
+	Size int64
+
+	// ModTime is the modification time of the file. It is stored with
+	// nanosecond precision, but not its location.
+	ModTime time.Time
+
+	// Digest is the digest of the image. It records the algorithm and grid.
+	Digest Digest
+
+	// Method is the block aggregation method that the digest was calculated
+	// with (see WithMethod()).
+	Method Method
+
+	// Level is the number of wavelet decompositions that the digest was
+	// calculated with (see WithLevel()). It only matters for wHash digests.
+	Level int
+}
+
+// HashIndex holds the digests of files so that they only have to be hashed
+// again when they change. It is safe to use concurrently.
+//
+// A hash-index file is a header (the magic "BHIX" and a version byte)
+// followed by a record for each entry. A record is the big-endian uint32
+// length of its payload, the payload, and the big-endian CRC-32 (IEEE) of the
+// payload. The payload is the length-prefixed (uvarint) path, the size and
+// the modification time in Unix seconds (varints), the nanoseconds of the
+// modification time, the method, and the level (uvarints), and then the binary
+// form of the digest (see Digest.MarshalBinary()).
+//
+// Records are only ever appended. When a path appears more than once, the
+// last record wins, so updates are written without rewriting the file.
+// SaveFile() rewrites it without the records that have been replaced. There
+// are no deletion records, so Delete() only takes effect in the file once it
+// is rewritten.
+type HashIndex struct {
+	entries map[string]HashIndexEntry
+
+	// inFile holds the paths whose current entry has a record in the file.
+	inFile map[string]struct{}
+
+	// staleRecords is the number of records in the file that have since been
+	// replaced or deleted.
+	staleRecords int
+
+	lock sync.RWMutex
+}
+
+// NewHashIndex returns an empty index.
+func NewHashIndex() *HashIndex {
+	return &HashIndex{
+		entries: make(map[string]HashIndexEntry),
+		inFile:  make(map[string]struct{}),
+	}
+}
+
+// Len returns the number of entries.
+func (hi *HashIndex) Len() int {
+	hi.lock.RLock()
+	defer hi.lock.RUnlock()
+
+	return len(hi.entries)
+}
+
+// StaleRecords returns the number of records that were loaded, appended, or
+// saved but have since been replaced or deleted. SaveFile() drops them.
+func (hi *HashIndex) StaleRecords() int {
+	hi.lock.RLock()
+	defer hi.lock.RUnlock()
+
+	return hi.staleRecords
+}
+
+// Get returns the entry for the given path.
+func (hi *HashIndex) Get(path string) (entry HashIndexEntry, found bool) {
+	hi.lock.RLock()
+	defer hi.lock.RUnlock()
+
+	entry, found = hi.entries[path]
+	return entry, found
+}
+
+// Lookup returns the stored digest for the given path if the file still has
+// the size and modification time that it had when it was hashed and the
+// digest was calculated with the given method and level. The level is only
+// checked for wHash digests. It is up to the caller to check that the digest
+// has the algorithm and grid that they want.
+func (hi *HashIndex) Lookup(path string, info os.FileInfo, method Method, level int) (digest Digest, found bool) {
+	entry, found := hi.Get(path)
+	if found == false || entry.Size != info.Size() || entry.ModTime.Equal(info.ModTime()) == false {
+		return Digest{}, false
+	}
+
+	if entry.Method != method || (entry.Digest.Algorithm() == AlgorithmWHash && entry.Level != level) {
+		return Digest{}, false
+	}
+
+	return entry.Digest, true
+}
+
+// Put stores the entry, replacing any other entry for its path. It isn't
+// written to the file until SaveFile() is called; AppendFile() does both.
+func (hi *HashIndex) Put(entry HashIndexEntry) {
+	hi.lock.Lock()
+	defer hi.lock.Unlock()
+
+	hi.forget(entry.Path)
+	hi.entries[entry.Path] = entry
+}
+
+// putRecord stores an entry whose record is in the file.
+func (hi *HashIndex) putRecord(entry HashIndexEntry) {
+	hi.forget(entry.Path)
+
+	hi.entries[entry.Path] = entry
+	hi.inFile[entry.Path] = struct{}{}
+}
+
+// forget marks the record of the current entry for the path, if it has one,
+// as stale.
+func (hi *HashIndex) forget(path string) {
+	if _, found := hi.inFile[path]; found == true {
+		delete(hi.inFile, path)
+		hi.staleRecords++
+	}
+}
+
+// Delete removes the entry for the given path. It returns false if there
+// wasn't one. The entry stays in the file until SaveFile() is called, so it
+// would be loaded again otherwise.
+func (hi *HashIndex) Delete(path string) (found bool) {
+	hi.lock.Lock()
+	defer hi.lock.Unlock()
+
+	if _, found := hi.entries[path]; found == false {
+		return false
+	}
+
+	hi.forget(path)
+	delete(hi.entries, path)
+
+	return true
+}
+
+// Entries returns every entry, ordered by path.
+func (hi *HashIndex) Entries() []HashIndexEntry {
+	hi.lock.RLock()
+	defer hi.lock.RUnlock()
+
+	return hi.sortedEntries()
+}
+
+func (hi *HashIndex) sortedEntries() []HashIndexEntry {
+	entries := make([]HashIndexEntry, 0, len(hi.entries))
+	for _, entry := range hi.entries {
+		entries = append(entries, entry)
+	}
+
+	sort.Slice(entries, func(i, j int) bool {
+		return entries[i].Path < entries[j].Path
+	})
+
+	return entries
+}
+
+// LoadHashIndex reads a hash index written by WriteTo(), SaveFile(), or
+// AppendFile(). ErrInvalidHashIndex is returned if the header is wrong. If a
+// record is truncated or fails its checksum (e.g. after a crash while
+// appending), the entries read before it are returned along with
+// ErrCorruptHashIndex so that the caller can keep them and repair the file
+// with SaveFile().
+func LoadHashIndex(r io.Reader) (hi *HashIndex, err error) {
+	br := bufio.NewReader(r)
+
+	header := make([]byte, hashIndexHeaderSize)
+
+	_, err = io.ReadFull(br, header)
+	if err == io.EOF || err == io.ErrUnexpectedEOF {
+		return nil, ErrInvalidHashIndex
+	} else if err != nil {
+		return nil, err
+	}
+
+	if string(header[:len(hashIndexMagic)]) != hashIndexMagic || header[len(hashIndexMagic)] != hashIndexVersion {
+		return nil, ErrInvalidHashIndex
+	}
+
+	hi = NewHashIndex()
+
+	for {
+		entry, err := readHashIndexRecord(br)
+		if err == io.EOF {
+			break
+		} else if err != nil {
+			return hi, err
+		}
+
+		hi.putRecord(entry)
+	}
+
+	return hi, nil
+}
+
+// LoadHashIndexFile reads the hash index at the given path (see
+// LoadHashIndex()). An empty index is returned if the file doesn't exist yet.
+func LoadHashIndexFile(filename string) (hi *HashIndex, err error) {
+	f, err := os.Open(filename)
+	if os.IsNotExist(err) == true {
+		return NewHashIndex(), nil
+	} else if err != nil {
+		return nil, err
+	}
+
+	defer f.Close()
+
+	return LoadHashIndex(f)
+}
+
+// readHashIndexRecord returns io.EOF if there are no more records.
+func readHashIndexRecord(r io.Reader) (entry HashIndexEntry, err error) {
+	var length [4]byte
+
+	_, err = io.ReadFull(r, length[:])
+	if err == io.EOF {
+		return HashIndexEntry{}, io.EOF
+	} else if err == io.ErrUnexpectedEOF {
+		return HashIndexEntry{}, ErrCorruptHashIndex
+	} else if err != nil {
+		return HashIndexEntry{}, err
+	}
+
+	payloadSize := binary.BigEndian.Uint32(length[:])
+	if payloadSize > maxHashIndexRecordSize {
+		return HashIndexEntry{}, ErrCorruptHashIndex
+	}
+
+	// The payload is followed by its checksum.
+	record := make([]byte, payloadSize+4)
+
+	_, err = io.ReadFull(r, record)
+	if err == io.EOF || err == io.ErrUnexpectedEOF {
+		return HashIndexEntry{}, ErrCorruptHashIndex
+	} else if err != nil {
+		return HashIndexEntry{}, err
+	}
+
+	payload := record[:payloadSize]
+	if crc32.ChecksumIEEE(payload) != binary.BigEndian.Uint32(record[payloadSize:]) {
+		return HashIndexEntry{}, ErrCorruptHashIndex
+	}
+
+	entry, err = decodeHashIndexPayload(payload)
+	if err != nil {
+		return HashIndexEntry{}, ErrCorruptHashIndex
+	}
+
+	return entry, nil
+}
+
+func decodeHashIndexPayload(payload []byte) (entry HashIndexEntry, err error) {
+	br := bytes.NewReader(payload)
+
+	pathLength, err := binary.ReadUvarint(br)
+	if err != nil {
+		return HashIndexEntry{}, err
+	} else if pathLength > uint64(br.Len()) {
+		return HashIndexEntry{}, ErrCorruptHashIndex
+	}
+
+	path := make([]byte, pathLength)
+
+	_, err = io.ReadFull(br, path)
+	if err != nil {
+		return HashIndexEntry{}, err
+	}
+
+	size, err := binary.ReadVarint(br)
+	if err != nil {
+		return HashIndexEntry{}, err
+	}
+
+	modTimeSeconds, err := binary.ReadVarint(br)
+	if err != nil {
+		return HashIndexEntry{}, err
+	}
+
+	modTimeNanoseconds, err := binary.ReadUvarint(br)
+	if err != nil {
+		return HashIndexEntry{}, err
+	} else if modTimeNanoseconds >= uint64(time.Second) {
+		return HashIndexEntry{}, ErrCorruptHashIndex
+	}
+
+	method, err := binary.ReadUvarint(br)
+	if err != nil {
+		return HashIndexEntry{}, err
+	}
+
+	level, err := binary.ReadUvarint(br)
+	if err != nil {
+		return HashIndexEntry{}, err
+	}
+
+	// The digest is the rest of the payload.
+
+	var digest Digest
+
+	err = digest.UnmarshalBinary(payload[len(payload)-br.Len():])
+	if err != nil {
+		return HashIndexEntry{}, err
+	}
+
+	entry = HashIndexEntry{
+		Path:    string(path),
+		Size:    size,
+		ModTime: time.Unix(modTimeSeconds, int64(modTimeNanoseconds)),
+		Digest:  digest,
+		Method:  Method(method),
+		Level:   int(level),
+	}
+
+	return entry, nil
+}
+
+// encodeHashIndexRecord returns the record for the entry, including its
+// length and checksum.
+func encodeHashIndexRecord(entry HashIndexEntry) (record []byte, err error) {
+	digest, err := entry.Digest.MarshalBinary()
+	if err != nil {
+		return nil, err
+	}
+
+	if entry.Method < 0 || entry.Level < 0 {
+		return nil, log.Errorf("method and level of [%s] can't be negative", entry.Path)
+	}
+
+	payload := make([]byte, 0, len(entry.Path)+len(digest)+6*binary.MaxVarintLen64)
+
+	var varint [binary.MaxVarintLen64]byte
+
+	n := binary.PutUvarint(varint[:], uint64(len(entry.Path)))
+	payload = append(payload, varint[:n]...)
+	payload = append(payload, entry.Path...)
+
+	n = binary.PutVarint(varint[:], entry.Size)
+	payload = append(payload, varint[:n]...)
+
+	// UnixNano() can't represent times much more than 292 years from 1970
+	// (including the zero time), so the seconds are stored separately.
+
+	n = binary.PutVarint(varint[:], entry.ModTime.Unix())
+	payload = append(payload, varint[:n]...)
+
+	n = binary.PutUvarint(varint[:], uint64(entry.ModTime.Nanosecond()))
+	payload = append(payload, varint[:n]...)
+
+	n = binary.PutUvarint(varint[:], uint64(entry.Method))
+	payload = append(payload, varint[:n]...)
+
+	n = binary.PutUvarint(varint[:], uint64(entry.Level))
+	payload = append(payload, varint[:n]...)
+
+	payload = append(payload, digest...)
+
+	if len(payload) > maxHashIndexRecordSize {
+		return nil, log.Errorf("hash-index record for [%s] is too large", entry.Path)
+	}
+
+	record = make([]byte, 4, 4+len(payload)+4)
+	binary.BigEndian.PutUint32(record, uint32(len(payload)))
+
+	record = append(record, payload...)
+
+	var checksum [4]byte
+	binary.BigEndian.PutUint32(checksum[:], crc32.ChecksumIEEE(payload))
+
+	record = append(record, checksum[:]...)
+
+	return record, nil
+}
+
+func writeHashIndexHeader(w io.Writer) (err error) {
+	_, err = w.Write(append([]byte(hashIndexMagic), hashIndexVersion))
+	return err
+}
+
+// WriteTo writes the header and a record for every entry, ordered by path.
+func (hi *HashIndex) WriteTo(w io.Writer) (n int64, err error) {
+	hi.lock.RLock()
+	defer hi.lock.RUnlock()
+
+	return hi.writeTo(w)
+}
+
+// writeTo expects the caller to hold the lock.
+func (hi *HashIndex) writeTo(w io.Writer) (n int64, err error) {
+	defer func() {
+		if state := recover(); state != nil {
+			err = log.Wrap(state.(error))
+		}
+	}()
+
+	cw := &countingWriter{w: w}
+
+	err = writeHashIndexHeader(cw)
+	log.PanicIf(err)
+
+	for _, entry := range hi.sortedEntries() {
+		record, err := encodeHashIndexRecord(entry)
+		log.PanicIf(err)
+
+		_, err = cw.Write(record)
+		log.PanicIf(err)
+	}
+
+	return cw.n, nil
+}
+
+type countingWriter struct {
+	w io.Writer
+	n int64
+}
+
+func (cw *countingWriter) Write(p []byte) (n int, err error) {
+	n, err = cw.w.Write(p)
+	cw.n += int64(n)
+
+	return n, err
+}
+
+// SaveFile replaces the file at the given path with the index, leaving out
+// any stale records. The file is written to a temporary file in the same
+// directory first and then renamed so that it's never left half-written.
+func (hi *HashIndex) SaveFile(filename string) (err error) {
+	defer func() {
+		if state := recover(); state != nil {
+			err = log.Wrap(state.(error))
+		}
+	}()
+
+	// Hold the lock until the file has been replaced. A record appended to the
+	// old file in the meantime would be lost.
+	hi.lock.Lock()
+	defer hi.lock.Unlock()
+
+	f, err := ioutil.TempFile(filepath.Dir(filename), filepath.Base(filename)+".*.tmp")
+	log.PanicIf(err)
+
+	// Clean up if we don't get as far as renaming it.
+	defer os.Remove(f.Name())
+	defer f.Close()
+
+	// Temporary files are only readable by us, so keep the mode of the file
+	// that we're replacing.
+	mode := os.FileMode(0644)
+	if fi, err := os.Stat(filename); err == nil {
+		mode = fi.Mode().Perm()
+	}
+
+	err = f.Chmod(mode)
+	log.PanicIf(err)
+
+	bw := bufio.NewWriter(f)
+
+	_, err = hi.writeTo(bw)
+	log.PanicIf(err)
+
+	err = bw.Flush()
+	log.PanicIf(err)
+
+	err = f.Sync()
+	log.PanicIf(err)
+
+	err = f.Close()
+	log.PanicIf(err)
+
+	err = os.Rename(f.Name(), filename)
+	log.PanicIf(err)
+
+	hi.staleRecords = 0
+
+	hi.inFile = make(map[string]struct{}, len(hi.entries))
+	for path := range hi.entries {
+		hi.inFile[path] = struct{}{}
+	}
+
+	return nil
+}
+
+// AppendFile stores the entries in the index and appends their records to the
+// file at the given path, which is created if it doesn't exist. Appending
+// each entry as it is hashed means that nothing is lost if the program is
+// interrupted. ErrInvalidHashIndex is returned if the file exists but isn't a
+// hash index.
+func (hi *HashIndex) AppendFile(filename string, entries ...HashIndexEntry) (err error) {
+	defer func() {
+		if state := recover(); state != nil {
+			err = log.Wrap(state.(error))
+		}
+	}()
+
+	hi.lock.Lock()
+	defer hi.lock.Unlock()
+
+	f, err := os.OpenFile(filename, os.O_RDWR|os.O_APPEND|os.O_CREATE, 0644)
+	log.PanicIf(err)
+
+	defer f.Close()
+
+	fi, err := f.Stat()
+	log.PanicIf(err)
+
+	buffer := new(bytes.Buffer)
+
+	if fi.Size() == 0 {
+		err := writeHashIndexHeader(buffer)
+		log.PanicIf(err)
+	} else {
+		header := make([]byte, hashIndexHeaderSize)
+
+		_, err := f.ReadAt(header, 0)
+		if err != nil || string(header[:len(hashIndexMagic)]) != hashIndexMagic || header[len(hashIndexMagic)] != hashIndexVersion {
+			return ErrInvalidHashIndex
+		}
+	}
+
+	for _, entry := range entries {
+		record, err := encodeHashIndexRecord(entry)
+		log.PanicIf(err)
+
+		buffer.Write(record)
+	}
+
+	// Write everything at once so that an interruption is less likely to
+	// leave a partial record.
+	_, err = f.Write(buffer.Bytes())
+	log.PanicIf(err)
+
+	err = f.Close()
+	log.PanicIf(err)
+
+	for _, entry := range entries {
+		hi.putRecord(entry)
+	}
+
+	return nil
+}
@@ -0,0 +1,560 @@
+package blockhash
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path"
+	"reflect"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/dsoprea/go-logging"
+)
+
+var (
+	_ io.WriterTo = new(HashIndex)
+)
+
+func getTestHashIndexEntries() []HashIndexEntry {
+	entries := make([]HashIndexEntry, 0)
+
+	modTime := time.Date(2020, 7, 10, 18, 49, 22, 123456789, time.UTC)
+
+	for i, digest := range getTestDigests() {
+		entries = append(entries, HashIndexEntry{
+			Path:    path.Join("photos", digest.Algorithm().String(), digest.String()),
+			Size:    int64(i * 1000),
+			ModTime: modTime.Add(time.Duration(i) * time.Second),
+			Digest:  digest,
+			Method:  Method(i % 2),
+			Level:   i,
+		})
+	}
+
+	return entries
+}
+
+func getTestHashIndexFilepath() (filepath string, cleanup func()) {
+	dirpath, err := ioutil.TempDir("", "hashindex")
+	log.PanicIf(err)
+
+	cleanup = func() {
+		os.RemoveAll(dirpath)
+	}
+
+	return path.Join(dirpath, "index"), cleanup
+}
+
+func assertHashIndexEntries(t *testing.T, hi *HashIndex, expected []HashIndexEntry) {
+	actual := hi.Entries()
+
+	if len(actual) != len(expected) {
+		t.Fatalf("entry count not correct: (%d) != (%d)", len(actual), len(expected))
+	}
+
+	for i, entry := range actual {
+		e := expected[i]
+
+		if entry.Path != e.Path || entry.Size != e.Size || entry.ModTime.Equal(e.ModTime) != true || entry.Method != e.Method || entry.Level != e.Level {
+			t.Fatalf("entry not correct: %v != %v", entry, e)
+		} else if entry.Digest.Equal(e.Digest) != true || entry.Digest.Algorithm() != e.Digest.Algorithm() {
+			t.Fatalf("digest not correct: [%s] != [%s]", entry.Digest, e.Digest)
+		}
+	}
+}
+
+func TestHashIndex_WriteTo(t *testing.T) {
+	entries := getTestHashIndexEntries()
+
+	hi := NewHashIndex()
+	for _, entry := range entries {
+		hi.Put(entry)
+	}
+
+	b := new(bytes.Buffer)
+
+	n, err := hi.WriteTo(b)
+	log.PanicIf(err)
+
+	if n != int64(b.Len()) {
+		t.Fatalf("size not correct: (%d) != (%d)", n, b.Len())
+	}
+
+	loaded, err := LoadHashIndex(b)
+	log.PanicIf(err)
+
+	assertHashIndexEntries(t, loaded, hi.Entries())
+
+	if loaded.StaleRecords() != 0 {
+		t.Fatalf("expected no stale records: (%d)", loaded.StaleRecords())
+	}
+}
+
+func TestHashIndex_WriteTo__Empty(t *testing.T) {
+	b := new(bytes.Buffer)
+
+	_, err := NewHashIndex().WriteTo(b)
+	log.PanicIf(err)
+
+	if b.String() != "BHIX\x01" {
+		t.Fatalf("empty index not correct: %q", b.String())
+	}
+
+	loaded, err := LoadHashIndex(b)
+	log.PanicIf(err)
+
+	if loaded.Len() != 0 {
+		t.Fatalf("expected no entries: (%d)", loaded.Len())
+	}
+}
+
+func TestHashIndex_AppendFile(t *testing.T) {
+	filepath, cleanup := getTestHashIndexFilepath()
+	defer cleanup()
+
+	entries := getTestHashIndexEntries()
+
+	hi, err := LoadHashIndexFile(filepath)
+	log.PanicIf(err)
+
+	if hi.Len() != 0 {
+		t.Fatalf("expected an empty index for a missing file: (%d)", hi.Len())
+	}
+
+	// Append one at a time, creating the file with the first.
+
+	for _, entry := range entries {
+		err := hi.AppendFile(filepath, entry)
+		log.PanicIf(err)
+	}
+
+	// Replace the first entry. The last record wins.
+
+	updated := entries[0]
+	updated.Size++
+	updated.Digest = entries[1].Digest
+
+	err = hi.AppendFile(filepath, updated)
+	log.PanicIf(err)
+
+	entries[0] = updated
+
+	if hi.StaleRecords() != 1 {
+		t.Fatalf("stale records not correct: (%d)", hi.StaleRecords())
+	}
+
+	loaded, err := LoadHashIndexFile(filepath)
+	log.PanicIf(err)
+
+	assertHashIndexEntries(t, loaded, hi.Entries())
+
+	if loaded.StaleRecords() != 1 {
+		t.Fatalf("stale records not correct after loading: (%d)", loaded.StaleRecords())
+	}
+
+	// Rewriting drops the stale record.
+
+	fi, err := os.Stat(filepath)
+	log.PanicIf(err)
+
+	err = loaded.SaveFile(filepath)
+	log.PanicIf(err)
+
+	if loaded.StaleRecords() != 0 {
+		t.Fatalf("expected no stale records after saving: (%d)", loaded.StaleRecords())
+	}
+
+	saved, err := os.Stat(filepath)
+	log.PanicIf(err)
+
+	if saved.Size() >= fi.Size() {
+		t.Fatalf("saved file not smaller: (%d) >= (%d)", saved.Size(), fi.Size())
+	}
+
+	if saved.Mode().Perm() != fi.Mode().Perm() {
+		t.Fatalf("mode not kept: (%s) != (%s)", saved.Mode(), fi.Mode())
+	}
+
+	reloaded, err := LoadHashIndexFile(filepath)
+	log.PanicIf(err)
+
+	assertHashIndexEntries(t, reloaded, hi.Entries())
+}
+
+func TestHashIndex_SaveFile__ConcurrentAppend(t *testing.T) {
+	filepath, cleanup := getTestHashIndexFilepath()
+	defer cleanup()
+
+	entries := getTestHashIndexEntries()
+
+	hi := NewHashIndex()
+
+	// Every record that is appended while the file is being rewritten has to
+	// survive the rewrite.
+
+	for round := 0; round < 10; round++ {
+		stop := make(chan struct{})
+		wg := new(sync.WaitGroup)
+
+		for _, entry := range entries {
+			wg.Add(1)
+
+			go func(entry HashIndexEntry) {
+				defer wg.Done()
+
+				basePath := entry.Path
+
+				for i := 0; ; i++ {
+					select {
+					case <-stop:
+						return
+					default:
+					}
+
+					entry.Path = fmt.Sprintf("%s-%d-%d", basePath, round, i)
+
+					err := hi.AppendFile(filepath, entry)
+					log.PanicIf(err)
+				}
+			}(entry)
+		}
+
+		err := hi.SaveFile(filepath)
+		log.PanicIf(err)
+
+		close(stop)
+		wg.Wait()
+
+		loaded, err := LoadHashIndexFile(filepath)
+		log.PanicIf(err)
+
+		assertHashIndexEntries(t, loaded, hi.Entries())
+	}
+}
+
+func TestHashIndex_AppendFile__NotAnIndex(t *testing.T) {
+	filepath, cleanup := getTestHashIndexFilepath()
+	defer cleanup()
+
+	err := ioutil.WriteFile(filepath, []byte("some other file"), 0644)
+	log.PanicIf(err)
+
+	err = NewHashIndex().AppendFile(filepath, getTestHashIndexEntries()[0])
+	if err != ErrInvalidHashIndex {
+		t.Fatalf("expected invalid-index error: %v", err)
+	}
+
+	data, err := ioutil.ReadFile(filepath)
+	log.PanicIf(err)
+
+	if string(data) != "some other file" {
+		t.Fatalf("file was modified")
+	}
+}
+
+func TestHashIndex_Delete(t *testing.T) {
+	entries := getTestHashIndexEntries()
+
+	hi := NewHashIndex()
+	for _, entry := range entries {
+		hi.Put(entry)
+	}
+
+	if hi.Delete(entries[0].Path) != true {
+		t.Fatalf("entry not found")
+	} else if hi.Delete(entries[0].Path) == true {
+		t.Fatalf("deleted entry found again")
+	}
+
+	if _, found := hi.Get(entries[0].Path); found == true {
+		t.Fatalf("deleted entry still stored")
+	}
+
+	// None of the entries were written, so there's nothing stale in the file.
+
+	if hi.Len() != len(entries)-1 || hi.StaleRecords() != 0 {
+		t.Fatalf("counts not correct: (%d) (%d)", hi.Len(), hi.StaleRecords())
+	}
+
+	hi.Put(entries[1])
+
+	if hi.StaleRecords() != 0 {
+		t.Fatalf("replacing an unwritten entry counted as stale: (%d)", hi.StaleRecords())
+	}
+}
+
+func TestHashIndex_Delete__File(t *testing.T) {
+	filepath, cleanup := getTestHashIndexFilepath()
+	defer cleanup()
+
+	entries := getTestHashIndexEntries()
+
+	err := NewHashIndex().AppendFile(filepath, entries...)
+	log.PanicIf(err)
+
+	hi, err := LoadHashIndexFile(filepath)
+	log.PanicIf(err)
+
+	if hi.Delete(entries[0].Path) != true {
+		t.Fatalf("entry not found")
+	}
+
+	// Replacing an entry in memory also leaves its record stale.
+	hi.Put(entries[1])
+
+	if hi.StaleRecords() != 2 {
+		t.Fatalf("stale records not correct: (%d)", hi.StaleRecords())
+	}
+
+	// The delete isn't in the file until it's rewritten.
+
+	loaded, err := LoadHashIndexFile(filepath)
+	log.PanicIf(err)
+
+	if _, found := loaded.Get(entries[0].Path); found != true {
+		t.Fatalf("expected deleted entry to be loaded before saving")
+	}
+
+	err = hi.SaveFile(filepath)
+	log.PanicIf(err)
+
+	if hi.StaleRecords() != 0 {
+		t.Fatalf("expected no stale records after saving: (%d)", hi.StaleRecords())
+	}
+
+	loaded, err = LoadHashIndexFile(filepath)
+	log.PanicIf(err)
+
+	if _, found := loaded.Get(entries[0].Path); found == true {
+		t.Fatalf("deleted entry loaded after saving")
+	}
+
+	assertHashIndexEntries(t, loaded, hi.Entries())
+
+	// Entries that were saved count as stale once they're deleted.
+
+	if hi.Delete(entries[1].Path) != true {
+		t.Fatalf("saved entry not found")
+	} else if hi.StaleRecords() != 1 {
+		t.Fatalf("stale records not correct after saving: (%d)", hi.StaleRecords())
+	}
+}
+
+func TestHashIndex_Lookup(t *testing.T) {
+	filepath, cleanup := getTestHashIndexFilepath()
+	defer cleanup()
+
+	err := ioutil.WriteFile(filepath, []byte("image"), 0644)
+	log.PanicIf(err)
+
+	fi, err := os.Stat(filepath)
+	log.PanicIf(err)
+
+	digest := getTestDigests()[0]
+
+	hi := NewHashIndex()
+	hi.Put(HashIndexEntry{
+		Path:    filepath,
+		Size:    fi.Size(),
+		ModTime: fi.ModTime(),
+		Digest:  digest,
+	})
+
+	found, ok := hi.Lookup(filepath, fi, MethodPrecise, 0)
+	if ok != true || found.Equal(digest) != true {
+		t.Fatalf("unchanged file not found")
+	}
+
+	// Change the modification time.
+
+	err = os.Chtimes(filepath, time.Now(), fi.ModTime().Add(time.Second))
+	log.PanicIf(err)
+
+	fi, err = os.Stat(filepath)
+	log.PanicIf(err)
+
+	if _, ok := hi.Lookup(filepath, fi, MethodPrecise, 0); ok == true {
+		t.Fatalf("touched file found")
+	}
+
+	if _, ok := hi.Lookup("other", fi, MethodPrecise, 0); ok == true {
+		t.Fatalf("unknown file found")
+	}
+}
+
+func TestHashIndex_Lookup__Settings(t *testing.T) {
+	filepath := path.Join(assetsPath, testImagePng1Small)
+
+	fi, err := os.Stat(filepath)
+	log.PanicIf(err)
+
+	f, i := getTestImage(testImagePng1Small)
+	defer f.Close()
+
+	hi := NewHashIndex()
+
+	// Switching to the quick method has to force a rehash, since it produces
+	// a different digest.
+
+	precise, err := NewBlockhashHasher()
+	log.PanicIf(err)
+
+	digest, err := precise.Hash(i)
+	log.PanicIf(err)
+
+	hi.Put(HashIndexEntry{
+		Path:    filepath,
+		Size:    fi.Size(),
+		ModTime: fi.ModTime(),
+		Digest:  digest,
+		Method:  MethodPrecise,
+		Level:   DefaultWHashLevel,
+	})
+
+	if _, found := hi.Lookup(filepath, fi, MethodPrecise, DefaultWHashLevel); found != true {
+		t.Fatalf("digest not found for the same settings")
+	} else if _, found := hi.Lookup(filepath, fi, MethodQuick, DefaultWHashLevel); found == true {
+		t.Fatalf("precise digest found for the quick method")
+	}
+
+	quick, err := NewBlockhashHasher(WithMethod(MethodQuick))
+	log.PanicIf(err)
+
+	quickDigest, err := quick.Hash(i)
+	log.PanicIf(err)
+
+	if quickDigest.Equal(digest) == true {
+		t.Fatalf("quick digest unexpectedly matches the precise digest")
+	}
+
+	// The level is ignored for anything but wHash.
+
+	if _, found := hi.Lookup(filepath, fi, MethodPrecise, 1); found != true {
+		t.Fatalf("blockhash digest not found for another level")
+	}
+
+	wh, err := NewWHash()
+	log.PanicIf(err)
+
+	digest, err = wh.Hash(i)
+	log.PanicIf(err)
+
+	hi.Put(HashIndexEntry{
+		Path:    filepath,
+		Size:    fi.Size(),
+		ModTime: fi.ModTime(),
+		Digest:  digest,
+		Method:  MethodPrecise,
+		Level:   DefaultWHashLevel,
+	})
+
+	if _, found := hi.Lookup(filepath, fi, MethodPrecise, 1); found == true {
+		t.Fatalf("wHash digest found for another level")
+	}
+}
+
+func TestLoadHashIndex__Invalid(t *testing.T) {
+	for _, data := range []string{"", "BHI", "BHIX\x00", "BHIX\x02", "PNG\x00\x01"} {
+		_, err := LoadHashIndex(bytes.NewBufferString(data))
+		if err != ErrInvalidHashIndex {
+			t.Fatalf("expected invalid-index error for %q: %v", data, err)
+		}
+	}
+}
+
+func TestLoadHashIndex__Corrupt(t *testing.T) {
+	entries := getTestHashIndexEntries()
+
+	hi := NewHashIndex()
+	for _, entry := range entries {
+		hi.Put(entry)
+	}
+
+	b := new(bytes.Buffer)
+
+	_, err := hi.WriteTo(b)
+	log.PanicIf(err)
+
+	data := b.Bytes()
+	sorted := hi.Entries()
+
+	firstRecord, err := encodeHashIndexRecord(sorted[0])
+	log.PanicIf(err)
+
+	secondRecord, err := encodeHashIndexRecord(sorted[1])
+	log.PanicIf(err)
+
+	// Every truncation within the second record keeps only the first entry.
+
+	start := hashIndexHeaderSize + len(firstRecord)
+	for end := start + 1; end < start+len(secondRecord); end++ {
+		loaded, err := LoadHashIndex(bytes.NewBuffer(data[:end]))
+		if err != ErrCorruptHashIndex {
+			t.Fatalf("expected corrupt-index error for truncation at (%d): %v", end, err)
+		}
+
+		assertHashIndexEntries(t, loaded, sorted[:1])
+	}
+
+	// Flip a bit in the digest of the second record.
+
+	corrupted := make([]byte, len(data))
+	copy(corrupted, data)
+
+	corrupted[start+len(secondRecord)-5] ^= 1
+
+	loaded, err := LoadHashIndex(bytes.NewBuffer(corrupted))
+	if err != ErrCorruptHashIndex {
+		t.Fatalf("expected corrupt-index error for checksum: %v", err)
+	}
+
+	assertHashIndexEntries(t, loaded, sorted[:1])
+}
+
+func TestEncodeHashIndexRecord(t *testing.T) {
+	entry := getTestHashIndexEntries()[0]
+
+	record, err := encodeHashIndexRecord(entry)
+	log.PanicIf(err)
+
+	decoded, err := readHashIndexRecord(bytes.NewBuffer(record))
+	log.PanicIf(err)
+
+	if reflect.DeepEqual(decoded.Digest.Bytes(), entry.Digest.Bytes()) != true || decoded.Path != entry.Path {
+		t.Fatalf("record did not round-trip: %v", decoded)
+	}
+
+	_, err = encodeHashIndexRecord(HashIndexEntry{Path: "empty"})
+	if err != ErrInvalidDigest {
+		t.Fatalf("expected invalid-digest error: %v", err)
+	}
+}
+
+func TestEncodeHashIndexRecord__ModTime(t *testing.T) {
+	entry := getTestHashIndexEntries()[0]
+
+	modTimes := []time.Time{
+		{},
+		time.Date(1600, 1, 2, 3, 4, 5, 6, time.UTC),
+		time.Date(1969, 12, 31, 23, 59, 59, 999999999, time.UTC),
+		time.Date(2500, 1, 2, 3, 4, 5, 6, time.UTC),
+	}
+
+	for _, modTime := range modTimes {
+		entry.ModTime = modTime
+
+		record, err := encodeHashIndexRecord(entry)
+		log.PanicIf(err)
+
+		decoded, err := readHashIndexRecord(bytes.NewBuffer(record))
+		log.PanicIf(err)
+
+		if decoded.ModTime.Equal(modTime) != true {
+			t.Fatalf("modification time did not round-trip: [%s] != [%s]", decoded.ModTime, modTime)
+		}
+	}
+}
@@ -0,0 +1,335 @@
+package index
+
+import (
+	"container/heap"
+	"sync"
+
+	"github.com/dsoprea/go-perceptualhash"
+)
+
+// BKTree is a Burkhard-Keller tree of digests. Every child of a node is keyed
+// by its distance from that node, and the triangle inequality lets queries
+// skip every subtree that can't contain a match. This works very well for
+// small search radii and becomes closer to a linear scan as the radius grows.
+//
+// All of the digests must have been calculated with the same grid and
+// algorithm. It is safe to use concurrently.
+type BKTree struct {
+	root *bkNode
+
+	// size is the number of stored IDs and emptyNodes the number of nodes
+	// whose IDs have all been deleted. Those are still needed to route
+	// queries until the tree is rebuilt.
+	size       int
+	nodes      int
+	emptyNodes int
+
+	compatibility compatibility
+	lock          sync.RWMutex
+}
+
+type bkNode struct {
+	digest blockhash.Digest
+	packed packedDigest
+
+	// ids are the IDs of every digest that is identical to this one.
+	ids      []string
+	children map[int]*bkNode
+}
+
+// NewBKTree returns an empty tree.
+func NewBKTree() *BKTree {
+	return new(BKTree)
+}
+
+// Len returns the number of stored IDs.
+func (t *BKTree) Len() int {
+	t.lock.RLock()
+	defer t.lock.RUnlock()
+
+	return t.size
+}
+
+// Insert stores the digest under the given ID. Storing the same ID and digest
+// again has no effect. An error is returned if the digest can't be compared
+// to the ones that are already stored.
+func (t *BKTree) Insert(id string, digest blockhash.Digest) (err error) {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+
+	err = t.compatibility.check(digest)
+	if err != nil {
+		return err
+	}
+
+	t.compatibility.set(digest)
+	t.insert(id, digest, newPackedDigest(digest))
+
+	return nil
+}
+
+func (t *BKTree) insert(id string, digest blockhash.Digest, packed packedDigest) {
+	if t.root == nil {
+		t.root = newBKNode(id, digest, packed)
+		t.size++
+		t.nodes++
+
+		return
+	}
+
+	node := t.root
+	for {
+		distance := node.packed.distance(packed)
+
+		if distance == 0 {
+			for _, existing := range node.ids {
+				if existing == id {
+					return
+				}
+			}
+
+			if len(node.ids) == 0 {
+				t.emptyNodes--
+			}
+
+			node.ids = append(node.ids, id)
+			t.size++
+
+			return
+		}
+
+		child, found := node.children[distance]
+		if found == false {
+			node.children[distance] = newBKNode(id, digest, packed)
+			t.size++
+			t.nodes++
+
+			return
+		}
+
+		node = child
+	}
+}
+
+func newBKNode(id string, digest blockhash.Digest, packed packedDigest) *bkNode {
+	return &bkNode{
+		digest:   digest,
+		packed:   packed,
+		ids:      []string{id},
+		children: make(map[int]*bkNode),
+	}
+}
+
+// Delete removes the ID that was stored with the given digest. It returns
+// false if it wasn't stored.
+func (t *BKTree) Delete(id string, digest blockhash.Digest) (found bool, err error) {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+
+	err = t.compatibility.check(digest)
+	if err != nil {
+		return false, err
+	}
+
+	packed := newPackedDigest(digest)
+
+	node := t.root
+	for node != nil {
+		distance := node.packed.distance(packed)
+		if distance != 0 {
+			node = node.children[distance]
+			continue
+		}
+
+		for i, existing := range node.ids {
+			if existing != id {
+				continue
+			}
+
+			node.ids = append(node.ids[:i], node.ids[i+1:]...)
+			t.size--
+
+			if len(node.ids) == 0 {
+				t.emptyNodes++
+			}
+
+			t.compact()
+
+			return true, nil
+		}
+
+		return false, nil
+	}
+
+	return false, nil
+}
+
+// compact rebuilds the tree once most of its nodes are empty so that deleted
+// digests don't slow down queries or hold on to memory indefinitely.
+func (t *BKTree) compact() {
+	if t.size == 0 {
+		t.root = nil
+		t.nodes = 0
+		t.emptyNodes = 0
+		t.compatibility.reset()
+
+		return
+	}
+
+	if t.emptyNodes*2 <= t.nodes {
+		return
+	}
+
+	old := t.root
+
+	t.root = nil
+	t.size = 0
+	t.nodes = 0
+	t.emptyNodes = 0
+
+	t.walk(old, func(node *bkNode) {
+		for _, id := range node.ids {
+			t.insert(id, node.digest, node.packed)
+		}
+	})
+}
+
+func (t *BKTree) walk(node *bkNode, cb func(node *bkNode)) {
+	cb(node)
+
+	for _, child := range node.children {
+		t.walk(child, cb)
+	}
+}
+
+// Radius returns every stored digest that differs from the given one by no
+// more than `radius` bits, ordered by distance and then by ID.
+func (t *BKTree) Radius(digest blockhash.Digest, radius int) (matches []Match, err error) {
+	t.lock.RLock()
+	defer t.lock.RUnlock()
+
+	err = t.compatibility.check(digest)
+	if err != nil {
+		return nil, err
+	}
+
+	matches = make([]Match, 0)
+
+	if t.root == nil || radius < 0 {
+		return matches, nil
+	}
+
+	packed := newPackedDigest(digest)
+
+	pending := []*bkNode{t.root}
+	for len(pending) > 0 {
+		node := pending[len(pending)-1]
+		pending = pending[:len(pending)-1]
+
+		distance := node.packed.distance(packed)
+
+		if distance <= radius {
+			for _, id := range node.ids {
+				matches = append(matches, Match{ID: id, Digest: node.digest, Distance: distance})
+			}
+		}
+
+		// Only children whose distance from this node is within `radius` of
+		// our distance from it can hold a match.
+		for childDistance, child := range node.children {
+			if childDistance >= distance-radius && childDistance <= distance+radius {
+				pending = append(pending, child)
+			}
+		}
+	}
+
+	sortMatches(matches)
+
+	return matches, nil
+}
+
+// Nearest returns the `k` stored digests that are closest to the given one,
+// ordered by distance and then by ID. Ties at the last place are broken by
+// ID.
+func (t *BKTree) Nearest(digest blockhash.Digest, k int) (matches []Match, err error) {
+	t.lock.RLock()
+	defer t.lock.RUnlock()
+
+	err = t.compatibility.check(digest)
+	if err != nil {
+		return nil, err
+	}
+
+	if t.root == nil || k <= 0 {
+		return make([]Match, 0), nil
+	}
+
+	packed := newPackedDigest(digest)
+
+	// Keep the best `k` matches in a heap with the worst on top. Once we have
+	// `k` of them, the worst distance bounds the search.
+
+	best := make(matchHeap, 0, k)
+	limit := digest.Len()
+
+	pending := []*bkNode{t.root}
+	for len(pending) > 0 {
+		node := pending[len(pending)-1]
+		pending = pending[:len(pending)-1]
+
+		distance := node.packed.distance(packed)
+
+		for _, id := range node.ids {
+			match := Match{ID: id, Digest: node.digest, Distance: distance}
+
+			if len(best) < k {
+				heap.Push(&best, match)
+			} else if matchLess(match, best[0]) == true {
+				best[0] = match
+				heap.Fix(&best, 0)
+			}
+
+			if len(best) == k {
+				limit = best[0].Distance
+			}
+		}
+
+		for childDistance, child := range node.children {
+			if childDistance >= distance-limit && childDistance <= distance+limit {
+				pending = append(pending, child)
+			}
+		}
+	}
+
+	matches = []Match(best)
+	sortMatches(matches)
+
+	return matches, nil
+}
+
+// matchHeap is a max-heap of matches, with the worst match on top.
+type matchHeap []Match
+
+func (mh matchHeap) Len() int {
+	return len(mh)
+}
+
+func (mh matchHeap) Less(i, j int) bool {
+	return matchLess(mh[j], mh[i])
+}
+
+func (mh matchHeap) Swap(i, j int) {
+	mh[i], mh[j] = mh[j], mh[i]
+}
+
+func (mh *matchHeap) Push(x interface{}) {
+	*mh = append(*mh, x.(Match))
+}
+
+func (mh *matchHeap) Pop() interface{} {
+	old := *mh
+	match := old[len(old)-1]
+	*mh = old[:len(old)-1]
+
+	return match
+}
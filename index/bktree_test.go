@@ -0,0 +1,184 @@
+package index
+
+import (
+	"math/rand"
+	"reflect"
+	"testing"
+
+	"github.com/dsoprea/go-logging"
+
+	"github.com/dsoprea/go-perceptualhash"
+)
+
+func TestBKTree_Radius(t *testing.T) {
+	r := rand.New(rand.NewSource(2))
+
+	for _, hashbits := range []int{8, 16} {
+		entries := getTestEntries(r, 30, 20, hashbits, hashbits)
+
+		tree := NewBKTree()
+		for _, entry := range entries {
+			err := tree.Insert(entry.id, entry.digest)
+			log.PanicIf(err)
+		}
+
+		if tree.Len() != len(entries) {
+			t.Fatalf("size not correct: (%d)", tree.Len())
+		}
+
+		for i := 0; i < 50; i++ {
+			query := flipBits(r, entries[r.Intn(len(entries))].digest, r.Intn(4))
+
+			for _, radius := range []int{0, 2, hashbits / 2, hashbits} {
+				actual, err := tree.Radius(query, radius)
+				log.PanicIf(err)
+
+				expected := bruteForceRadius(entries, query, radius)
+
+				if reflect.DeepEqual(matchIds(actual), matchIds(expected)) != true {
+					t.Fatalf("(%d) matches for radius (%d) not correct:\nactual: %v\nexpected: %v", hashbits, radius, matchIds(actual), matchIds(expected))
+				}
+			}
+		}
+	}
+}
+
+func TestBKTree_Nearest(t *testing.T) {
+	r := rand.New(rand.NewSource(3))
+
+	entries := getTestEntries(r, 40, 10, 16, 20)
+
+	tree := NewBKTree()
+	for _, entry := range entries {
+		err := tree.Insert(entry.id, entry.digest)
+		log.PanicIf(err)
+	}
+
+	for i := 0; i < 50; i++ {
+		query := flipBits(r, entries[r.Intn(len(entries))].digest, r.Intn(10))
+
+		for _, k := range []int{1, 5, 25, len(entries) + 10} {
+			actual, err := tree.Nearest(query, k)
+			log.PanicIf(err)
+
+			expected := bruteForceNearest(entries, query, k)
+
+			if reflect.DeepEqual(matchIds(actual), matchIds(expected)) != true {
+				t.Fatalf("nearest (%d) not correct:\nactual: %v\nexpected: %v", k, matchIds(actual), matchIds(expected))
+			}
+		}
+	}
+
+	matches, err := tree.Nearest(entries[0].digest, 0)
+	log.PanicIf(err)
+
+	if len(matches) != 0 {
+		t.Fatalf("expected no matches for k of zero")
+	}
+}
+
+func TestBKTree_Insert__Duplicates(t *testing.T) {
+	r := rand.New(rand.NewSource(4))
+
+	digest := getTestDigest(r, 8)
+
+	tree := NewBKTree()
+
+	for _, id := range []string{"a", "b", "a"} {
+		err := tree.Insert(id, digest)
+		log.PanicIf(err)
+	}
+
+	if tree.Len() != 2 {
+		t.Fatalf("size not correct: (%d)", tree.Len())
+	}
+
+	matches, err := tree.Radius(digest, 0)
+	log.PanicIf(err)
+
+	if reflect.DeepEqual(matchIds(matches), []string{"a/0", "b/0"}) != true {
+		t.Fatalf("matches not correct: %v", matchIds(matches))
+	}
+}
+
+func TestBKTree_Insert__Mismatch(t *testing.T) {
+	r := rand.New(rand.NewSource(5))
+
+	tree := NewBKTree()
+
+	err := tree.Insert("a", getTestDigest(r, 8))
+	log.PanicIf(err)
+
+	err = tree.Insert("b", getTestDigest(r, 16))
+	if err != blockhash.ErrDigestSizeMismatch {
+		t.Fatalf("expected size-mismatch error: %v", err)
+	}
+
+	_, err = tree.Radius(getTestDigest(r, 16), 10)
+	if err != blockhash.ErrDigestSizeMismatch {
+		t.Fatalf("expected size-mismatch error: %v", err)
+	}
+}
+
+func TestBKTree_Delete(t *testing.T) {
+	r := rand.New(rand.NewSource(6))
+
+	entries := getTestEntries(r, 20, 10, 8, 6)
+
+	tree := NewBKTree()
+	for _, entry := range entries {
+		err := tree.Insert(entry.id, entry.digest)
+		log.PanicIf(err)
+	}
+
+	// Delete most of the entries so that the tree gets rebuilt along the way.
+
+	remaining := make([]testEntry, 0)
+	for i, entry := range entries {
+		if i%5 == 0 {
+			remaining = append(remaining, entry)
+			continue
+		}
+
+		found, err := tree.Delete(entry.id, entry.digest)
+		log.PanicIf(err)
+
+		if found != true {
+			t.Fatalf("entry not found: [%s]", entry.id)
+		}
+	}
+
+	if tree.Len() != len(remaining) {
+		t.Fatalf("size not correct after deleting: (%d) != (%d)", tree.Len(), len(remaining))
+	}
+
+	found, err := tree.Delete(entries[1].id, entries[1].digest)
+	log.PanicIf(err)
+
+	if found == true {
+		t.Fatalf("deleted entry found again")
+	}
+
+	for i := 0; i < 20; i++ {
+		query := flipBits(r, entries[r.Intn(len(entries))].digest, r.Intn(3))
+
+		actual, err := tree.Radius(query, 8)
+		log.PanicIf(err)
+
+		expected := bruteForceRadius(remaining, query, 8)
+
+		if reflect.DeepEqual(matchIds(actual), matchIds(expected)) != true {
+			t.Fatalf("matches after deleting not correct:\nactual: %v\nexpected: %v", matchIds(actual), matchIds(expected))
+		}
+	}
+
+	// Emptying the tree allows digests of another size.
+
+	for _, entry := range remaining {
+		_, err := tree.Delete(entry.id, entry.digest)
+		log.PanicIf(err)
+	}
+
+	err = tree.Insert("other", getTestDigest(r, 16))
+	log.PanicIf(err)
+}
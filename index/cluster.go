@@ -0,0 +1,137 @@
+package index
+
+import (
+	"sort"
+
+	"github.com/dsoprea/go-perceptualhash"
+)
+
+// Item is a digest to be clustered.
+type Item struct {
+	// ID identifies the digest in the groups. Items with the same ID are
+	// always in the same group.
+	ID string
+
+	// Digest is the digest of the image.
+	Digest blockhash.Digest
+}
+
+// Cluster groups the items into connected components: two items are in the
+// same group if they are within `threshold` bits of each other or are linked
+// by a chain of items that are. Every item is in exactly one group, so items
+// without any near-duplicates are returned as groups of one.
+//
+// The IDs in each group are sorted and the groups are ordered by size
+// (largest first) and then by their first ID, so the result is deterministic.
+// An error is returned if the digests can't be compared to each other.
+func Cluster(items []Item, threshold int) (groups [][]string, err error) {
+	if len(items) == 0 {
+		return make([][]string, 0), nil
+	}
+
+	mi, err := NewMultiIndex(0)
+	if err != nil {
+		return nil, err
+	}
+
+	uf := newUnionFind(len(items))
+
+	// positions maps each ID to the first item with it.
+	positions := make(map[string]int, len(items))
+
+	// Search for each item before inserting it so that every pair is only
+	// found once.
+
+	for i, item := range items {
+		matches, err := mi.Radius(item.Digest, threshold)
+		if err != nil {
+			return nil, err
+		}
+
+		for _, match := range matches {
+			uf.union(i, positions[match.ID])
+		}
+
+		if first, found := positions[item.ID]; found == true {
+			uf.union(i, first)
+		} else {
+			positions[item.ID] = i
+		}
+
+		err = mi.Insert(item.ID, item.Digest)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	byRoot := make(map[int][]string)
+	for id, i := range positions {
+		root := uf.find(i)
+		byRoot[root] = append(byRoot[root], id)
+	}
+
+	groups = make([][]string, 0, len(byRoot))
+	for _, group := range byRoot {
+		sort.Strings(group)
+		groups = append(groups, group)
+	}
+
+	sort.Slice(groups, func(i, j int) bool {
+		if len(groups[i]) != len(groups[j]) {
+			return len(groups[i]) > len(groups[j])
+		}
+
+		return groups[i][0] < groups[j][0]
+	})
+
+	return groups, nil
+}
+
+// unionFind is a disjoint-set forest over the integers [0, n).
+type unionFind struct {
+	parents []int
+	sizes   []int
+}
+
+func newUnionFind(n int) *unionFind {
+	uf := &unionFind{
+		parents: make([]int, n),
+		sizes:   make([]int, n),
+	}
+
+	for i := range uf.parents {
+		uf.parents[i] = i
+		uf.sizes[i] = 1
+	}
+
+	return uf
+}
+
+// find returns the representative of the set that contains `i`, halving the
+// path along the way.
+func (uf *unionFind) find(i int) int {
+	for uf.parents[i] != i {
+		uf.parents[i] = uf.parents[uf.parents[i]]
+		i = uf.parents[i]
+	}
+
+	return i
+}
+
+// union merges the sets that contain `i` and `j`, attaching the smaller set to
+// the larger.
+func (uf *unionFind) union(i, j int) {
+	i = uf.find(i)
+	j = uf.find(j)
+
+	if i == j {
+		return
+	}
+
+	if uf.sizes[i] < uf.sizes[j] {
+		i, j = j, i
+	}
+
+	uf.parents[j] = i
+	uf.sizes[i] += uf.sizes[j]
+}
@@ -0,0 +1,175 @@
+package index
+
+import (
+	"math/rand"
+	"reflect"
+	"sort"
+	"testing"
+
+	"github.com/dsoprea/go-logging"
+
+	"github.com/dsoprea/go-perceptualhash"
+)
+
+// bruteForceCluster compares every pair of items.
+func bruteForceCluster(items []Item, threshold int) [][]string {
+	uf := newUnionFind(len(items))
+
+	for i := range items {
+		for j := i + 1; j < len(items); j++ {
+			distance, err := items[i].Digest.Distance(items[j].Digest)
+			log.PanicIf(err)
+
+			if distance <= threshold || items[i].ID == items[j].ID {
+				uf.union(i, j)
+			}
+		}
+	}
+
+	byRoot := make(map[int][]string)
+	seen := make(map[string]bool)
+	for i, item := range items {
+		if seen[item.ID] == true {
+			continue
+		}
+
+		seen[item.ID] = true
+
+		root := uf.find(i)
+		byRoot[root] = append(byRoot[root], item.ID)
+	}
+
+	groups := make([][]string, 0)
+	for _, group := range byRoot {
+		sort.Strings(group)
+		groups = append(groups, group)
+	}
+
+	sort.Slice(groups, func(i, j int) bool {
+		if len(groups[i]) != len(groups[j]) {
+			return len(groups[i]) > len(groups[j])
+		}
+
+		return groups[i][0] < groups[j][0]
+	})
+
+	return groups
+}
+
+func getTestItems(entries []testEntry) []Item {
+	items := make([]Item, len(entries))
+	for i, entry := range entries {
+		items[i] = Item{ID: entry.id, Digest: entry.digest}
+	}
+
+	return items
+}
+
+func TestCluster(t *testing.T) {
+	r := rand.New(rand.NewSource(7))
+
+	for _, hashbits := range []int{8, 16} {
+		items := getTestItems(getTestEntries(r, 40, 8, hashbits, hashbits/2))
+
+		// Shuffle so that families aren't inserted together.
+		r.Shuffle(len(items), func(i, j int) {
+			items[i], items[j] = items[j], items[i]
+		})
+
+		for _, threshold := range []int{0, 2, hashbits / 2, hashbits, hashbits * 4} {
+			actual, err := Cluster(items, threshold)
+			log.PanicIf(err)
+
+			expected := bruteForceCluster(items, threshold)
+
+			if reflect.DeepEqual(actual, expected) != true {
+				t.Fatalf("(%d) groups for threshold (%d) not correct:\nactual: %v\nexpected: %v", hashbits, threshold, actual, expected)
+			}
+		}
+	}
+}
+
+func TestCluster__Chain(t *testing.T) {
+	// "a" and "c" are too far apart to be grouped directly, but "b" links
+	// them. "d" is on its own.
+
+	digests := map[string]string{
+		"a": "0000000000000000",
+		"b": "000000000000000f",
+		"c": "00000000000000ff",
+		"d": "ffffffffffffffff",
+		"e": "ff00000000000000",
+		"f": "ff0000000000000f",
+	}
+
+	items := make([]Item, 0)
+	for _, id := range []string{"d", "c", "f", "a", "e", "b"} {
+		digest, err := blockhash.ParseDigest(digests[id], 8)
+		log.PanicIf(err)
+
+		items = append(items, Item{ID: id, Digest: digest})
+	}
+
+	groups, err := Cluster(items, 4)
+	log.PanicIf(err)
+
+	expected := [][]string{
+		{"a", "b", "c"},
+		{"e", "f"},
+		{"d"},
+	}
+
+	if reflect.DeepEqual(groups, expected) != true {
+		t.Fatalf("groups not correct: %v", groups)
+	}
+}
+
+func TestCluster__DuplicateIds(t *testing.T) {
+	a, err := blockhash.ParseDigest("0000000000000000", 8)
+	log.PanicIf(err)
+
+	b, err := blockhash.ParseDigest("ffffffffffffffff", 8)
+	log.PanicIf(err)
+
+	c, err := blockhash.ParseDigest("fffffffffffffff0", 8)
+	log.PanicIf(err)
+
+	// Both digests of "x" belong to one group, which "y" joins through the
+	// second of them.
+
+	items := []Item{
+		{ID: "x", Digest: a},
+		{ID: "x", Digest: b},
+		{ID: "y", Digest: c},
+	}
+
+	groups, err := Cluster(items, 4)
+	log.PanicIf(err)
+
+	if reflect.DeepEqual(groups, [][]string{{"x", "y"}}) != true {
+		t.Fatalf("groups not correct: %v", groups)
+	}
+}
+
+func TestCluster__Empty(t *testing.T) {
+	groups, err := Cluster(nil, 10)
+	log.PanicIf(err)
+
+	if len(groups) != 0 {
+		t.Fatalf("expected no groups: %v", groups)
+	}
+}
+
+func TestCluster__Mismatch(t *testing.T) {
+	r := rand.New(rand.NewSource(8))
+
+	items := []Item{
+		{ID: "a", Digest: getTestDigest(r, 8)},
+		{ID: "b", Digest: getTestDigest(r, 16)},
+	}
+
+	_, err := Cluster(items, 10)
+	if err != blockhash.ErrDigestSizeMismatch {
+		t.Fatalf("expected size-mismatch error: %v", err)
+	}
+}
@@ -0,0 +1,148 @@
+// Package index provides structures for finding stored digests that are
+// within a given Hamming distance of another digest, which is how
+// near-duplicate images are found without comparing against every image.
+package index
+
+import (
+	"math/bits"
+	"sort"
+
+	"github.com/dsoprea/go-perceptualhash"
+)
+
+// Index is implemented by every index in this package.
+type Index interface {
+	// Len returns the number of stored IDs.
+	Len() int
+
+	// Insert stores the digest under the given ID.
+	Insert(id string, digest blockhash.Digest) (err error)
+
+	// Delete removes the ID that was stored with the given digest. It returns
+	// false if it wasn't stored.
+	Delete(id string, digest blockhash.Digest) (found bool, err error)
+
+	// Radius returns every stored digest that differs from the given one by
+	// no more than `radius` bits, ordered by distance and then by ID.
+	Radius(digest blockhash.Digest, radius int) (matches []Match, err error)
+
+	// Nearest returns the `k` stored digests that are closest to the given
+	// one, ordered by distance and then by ID.
+	Nearest(digest blockhash.Digest, k int) (matches []Match, err error)
+}
+
+// Match is a stored digest that was found by a query.
+type Match struct {
+	// ID identifies the digest. It is whatever was given when the digest was
+	// inserted.
+	ID string
+
+	// Digest is the stored digest.
+	Digest blockhash.Digest
+
+	// Distance is the number of bits that differ from the query.
+	Distance int
+}
+
+// sortMatches orders matches by distance and then by ID so that results are
+// deterministic.
+func sortMatches(matches []Match) {
+	sort.Slice(matches, func(i, j int) bool {
+		return matchLess(matches[i], matches[j])
+	})
+}
+
+func matchLess(a, b Match) bool {
+	if a.Distance != b.Distance {
+		return a.Distance < b.Distance
+	}
+
+	return a.ID < b.ID
+}
+
+// packedDigest holds the bits of a digest in 64-bit words (see
+// blockhash.Digest.Words()) so that distances can be calculated a word at a
+// time.
+type packedDigest []uint64
+
+func newPackedDigest(digest blockhash.Digest) packedDigest {
+	return packedDigest(digest.Words())
+}
+
+// bitRange returns `length` (no more than 64) bits starting at the given
+// offset, with the first bit in the most-significant position.
+func (pd packedDigest) bitRange(start, length int) uint64 {
+	value := uint64(0)
+
+	for length > 0 {
+		word := pd[start/64]
+		offset := start % 64
+
+		take := 64 - offset
+		if take > length {
+			take = length
+		}
+
+		value = value<<uint(take) | (word<<uint(offset))>>uint(64-take)
+
+		start += take
+		length -= take
+	}
+
+	return value
+}
+
+// equal returns true if both digests have the same bits.
+func (pd packedDigest) equal(other packedDigest) bool {
+	for i, word := range pd {
+		if other[i] != word {
+			return false
+		}
+	}
+
+	return true
+}
+
+// distance returns the number of bits that differ. Both digests must have the
+// same number of bits.
+func (pd packedDigest) distance(other packedDigest) int {
+	distance := 0
+	for i, word := range pd {
+		distance += bits.OnesCount64(word ^ other[i])
+	}
+
+	return distance
+}
+
+// compatibility checks that digests can be compared against the first digest
+// that was stored, so that mismatched digests are rejected when they are
+// inserted or queried rather than silently producing meaningless distances.
+type compatibility struct {
+	reference *blockhash.Digest
+}
+
+// check returns blockhash.ErrDigestSizeMismatch or
+// blockhash.ErrAlgorithmMismatch if the digest can't be compared to the
+// reference.
+func (c *compatibility) check(digest blockhash.Digest) (err error) {
+	if c.reference == nil {
+		return nil
+	}
+
+	_, err = c.reference.Distance(digest)
+	return err
+}
+
+// set makes the digest the reference if there isn't one yet. A reference that
+// doesn't know its algorithm is replaced by one that does so that the
+// algorithm is enforced from then on.
+func (c *compatibility) set(digest blockhash.Digest) {
+	if c.reference == nil || (c.reference.Algorithm() == 0 && digest.Algorithm() != 0) {
+		c.reference = &digest
+	}
+}
+
+// reset forgets the reference once the index is empty.
+func (c *compatibility) reset() {
+	c.reference = nil
+}
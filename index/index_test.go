@@ -0,0 +1,159 @@
+package index
+
+import (
+	"fmt"
+	"math/rand"
+	"testing"
+
+	"github.com/dsoprea/go-logging"
+
+	"github.com/dsoprea/go-perceptualhash"
+)
+
+// Make sure that every index satisfies the interface.
+var (
+	_ Index = new(BKTree)
+	_ Index = new(MultiIndex)
+)
+
+// getTestDigest returns a random digest with (hashbits x hashbits) bits.
+func getTestDigest(r *rand.Rand, hashbits int) blockhash.Digest {
+	hexdigest := make([]byte, hashbits*hashbits/4)
+	for i := range hexdigest {
+		hexdigest[i] = "0123456789abcdef"[r.Intn(16)]
+	}
+
+	digest, err := blockhash.ParseDigest(string(hexdigest), hashbits)
+	log.PanicIf(err)
+
+	return digest
+}
+
+// flipBits returns a copy of the digest with `count` random bits flipped.
+func flipBits(r *rand.Rand, digest blockhash.Digest, count int) blockhash.Digest {
+	bits := make([]int, digest.Len())
+	for i := range bits {
+		bits[i] = digest.Bit(i)
+	}
+
+	for _, i := range r.Perm(len(bits))[:count] {
+		bits[i] ^= 1
+	}
+
+	hexdigest := ""
+	for i := 0; i < len(bits); i += 4 {
+		hexdigest += fmt.Sprintf("%x", bits[i]<<3|bits[i+1]<<2|bits[i+2]<<1|bits[i+3])
+	}
+
+	flipped, err := blockhash.ParseGridDigest(hexdigest, digest.Columns(), digest.Rows())
+	log.PanicIf(err)
+
+	return flipped
+}
+
+type testEntry struct {
+	id     string
+	digest blockhash.Digest
+}
+
+// getTestEntries returns families of near-duplicate digests: each family is
+// a random digest and variations of it with a few bits flipped.
+func getTestEntries(r *rand.Rand, families, size, hashbits, maxFlipped int) []testEntry {
+	entries := make([]testEntry, 0)
+
+	for i := 0; i < families; i++ {
+		base := getTestDigest(r, hashbits)
+
+		for j := 0; j < size; j++ {
+			entries = append(entries, testEntry{
+				id:     fmt.Sprintf("%d-%d", i, j),
+				digest: flipBits(r, base, r.Intn(maxFlipped+1)),
+			})
+		}
+	}
+
+	return entries
+}
+
+func bruteForceRadius(entries []testEntry, digest blockhash.Digest, radius int) []Match {
+	matches := make([]Match, 0)
+
+	for _, entry := range entries {
+		distance, err := entry.digest.Distance(digest)
+		log.PanicIf(err)
+
+		if distance <= radius {
+			matches = append(matches, Match{ID: entry.id, Digest: entry.digest, Distance: distance})
+		}
+	}
+
+	sortMatches(matches)
+
+	return matches
+}
+
+func bruteForceNearest(entries []testEntry, digest blockhash.Digest, k int) []Match {
+	matches := bruteForceRadius(entries, digest, digest.Len())
+	if len(matches) > k {
+		matches = matches[:k]
+	}
+
+	return matches
+}
+
+func matchIds(matches []Match) []string {
+	ids := make([]string, len(matches))
+	for i, match := range matches {
+		ids[i] = fmt.Sprintf("%s/%d", match.ID, match.Distance)
+	}
+
+	return ids
+}
+
+func TestPackedDigest_Distance(t *testing.T) {
+	r := rand.New(rand.NewSource(1))
+
+	// 144 bits doesn't fill the last word.
+	for _, hashbits := range []int{8, 16, 12} {
+		for i := 0; i < 20; i++ {
+			a := getTestDigest(r, hashbits)
+			b := getTestDigest(r, hashbits)
+
+			expected, err := a.Distance(b)
+			log.PanicIf(err)
+
+			actual := newPackedDigest(a).distance(newPackedDigest(b))
+			if actual != expected {
+				t.Fatalf("distance not correct: (%d) != (%d)", actual, expected)
+			}
+		}
+	}
+}
+
+func TestPackedDigest_BitRange(t *testing.T) {
+	digest, err := blockhash.ParseGridDigest("0123456789abcdeffedcba9876543210aa", 17, 8)
+	log.PanicIf(err)
+
+	packed := newPackedDigest(digest)
+
+	cases := []struct {
+		start    int
+		length   int
+		expected uint64
+	}{
+		{0, 8, 0x01},
+		{4, 8, 0x12},
+		{0, 64, 0x0123456789abcdef},
+		{60, 8, 0xff},
+		{56, 64, 0xeffedcba98765432},
+		{128, 8, 0xaa},
+		{129, 7, 0x2a},
+	}
+
+	for _, c := range cases {
+		actual := packed.bitRange(c.start, c.length)
+		if actual != c.expected {
+			t.Fatalf("bits (%d) to (%d) not correct: (%x) != (%x)", c.start, c.start+c.length, actual, c.expected)
+		}
+	}
+}
@@ -0,0 +1,431 @@
+package index
+
+import (
+	"errors"
+	"sync"
+
+	"github.com/dsoprea/go-logging"
+
+	"github.com/dsoprea/go-perceptualhash"
+)
+
+const (
+	// DefaultChunkBits is the approximate size of the chunks that digests are
+	// split into when the number of chunks isn't given. A 256-bit blockhash
+	// digest is split into sixteen chunks.
+	DefaultChunkBits = 16
+
+	// maxChunkBits is the largest chunk that fits into a table key.
+	maxChunkBits = 64
+)
+
+var (
+	// ErrInvalidChunks indicates that digests can't be split into the
+	// requested number of chunks: there has to be at least one chunk, no more
+	// chunks than bits, and no more than 64 bits in a chunk.
+	ErrInvalidChunks = errors.New("invalid number of chunks")
+)
+
+// MultiIndex is a multi-index hashing (MIH) index. Every digest is split into
+// `m` chunks and each chunk is indexed in its own table. If two digests are
+// within `r` bits of each other, at least one of their chunks has to be
+// within `r / m` bits (the pigeonhole principle), so a radius search only has
+// to look up the few chunk values near those of the query and check the
+// digests that it finds. The search is exact.
+//
+// This stays fast for the large digests and radii (e.g. 256 bits within 10 or
+// 20) where a BKTree has to visit most of its nodes. It is less suited to
+// very large radii relative to the chunk size, where it falls back to
+// checking every digest.
+//
+// All of the digests must have been calculated with the same grid and
+// algorithm. It is safe to use concurrently.
+type MultiIndex struct {
+	// chunkCount is the requested number of chunks, or zero to choose one from
+	// the size of the first digest.
+	chunkCount int
+
+	// chunks and tables are set up when the first digest is inserted. Each
+	// table maps the value of a chunk to every entry with that value.
+	chunks []mihChunk
+	tables []map[uint64][]*mihEntry
+
+	columns int
+	rows    int
+	size    int
+
+	compatibility compatibility
+	lock          sync.RWMutex
+}
+
+type mihChunk struct {
+	start  int
+	length int
+}
+
+// mihEntry is a stored digest. Only the packed bits are kept so that very
+// large numbers of digests fit in memory; the digest is rebuilt for matches.
+type mihEntry struct {
+	id        string
+	algorithm blockhash.Algorithm
+	packed    packedDigest
+}
+
+// NewMultiIndex returns an empty index that splits digests into the given
+// number of chunks. If `chunks` is zero, digests are split into chunks of
+// about DefaultChunkBits bits.
+func NewMultiIndex(chunks int) (mi *MultiIndex, err error) {
+	if chunks < 0 {
+		return nil, ErrInvalidChunks
+	}
+
+	mi = &MultiIndex{
+		chunkCount: chunks,
+	}
+
+	return mi, nil
+}
+
+// Len returns the number of stored IDs.
+func (mi *MultiIndex) Len() int {
+	mi.lock.RLock()
+	defer mi.lock.RUnlock()
+
+	return mi.size
+}
+
+// setup divides digests with the given number of bits into chunks.
+func (mi *MultiIndex) setup(digest blockhash.Digest) (err error) {
+	bitCount := digest.Len()
+
+	chunkCount := mi.chunkCount
+	if chunkCount == 0 {
+		chunkCount = (bitCount + DefaultChunkBits - 1) / DefaultChunkBits
+	}
+
+	if chunkCount > bitCount || (bitCount+chunkCount-1)/chunkCount > maxChunkBits {
+		return ErrInvalidChunks
+	}
+
+	// The chunks differ in size by at most one bit.
+
+	mi.chunks = make([]mihChunk, chunkCount)
+	mi.tables = make([]map[uint64][]*mihEntry, chunkCount)
+
+	for i := range mi.chunks {
+		start := i * bitCount / chunkCount
+		end := (i + 1) * bitCount / chunkCount
+
+		mi.chunks[i] = mihChunk{start: start, length: end - start}
+		mi.tables[i] = make(map[uint64][]*mihEntry)
+	}
+
+	mi.columns = digest.Columns()
+	mi.rows = digest.Rows()
+
+	return nil
+}
+
+// reset forgets the layout once the index is empty so that digests of
+// another size can be stored.
+func (mi *MultiIndex) reset() {
+	mi.chunks = nil
+	mi.tables = nil
+	mi.compatibility.reset()
+}
+
+// Insert stores the digest under the given ID. Storing the same ID and digest
+// again has no effect. An error is returned if the digest can't be compared
+// to the ones that are already stored or can't be split into chunks.
+func (mi *MultiIndex) Insert(id string, digest blockhash.Digest) (err error) {
+	mi.lock.Lock()
+	defer mi.lock.Unlock()
+
+	err = mi.compatibility.check(digest)
+	if err != nil {
+		return err
+	}
+
+	if mi.chunks == nil {
+		err := mi.setup(digest)
+		if err != nil {
+			return err
+		}
+	}
+
+	mi.compatibility.set(digest)
+	mi.insert(id, digest.Algorithm(), newPackedDigest(digest))
+
+	return nil
+}
+
+func (mi *MultiIndex) insert(id string, algorithm blockhash.Algorithm, packed packedDigest) {
+	if mi.find(id, packed) != nil {
+		return
+	}
+
+	entry := &mihEntry{
+		id:        id,
+		algorithm: algorithm,
+		packed:    packed,
+	}
+
+	for i, c := range mi.chunks {
+		key := packed.bitRange(c.start, c.length)
+		mi.tables[i][key] = append(mi.tables[i][key], entry)
+	}
+
+	mi.size++
+}
+
+// find returns the entry with the given ID and bits, if any.
+func (mi *MultiIndex) find(id string, packed packedDigest) *mihEntry {
+	c := mi.chunks[0]
+	key := packed.bitRange(c.start, c.length)
+
+	for _, entry := range mi.tables[0][key] {
+		if entry.id == id && entry.packed.equal(packed) == true {
+			return entry
+		}
+	}
+
+	return nil
+}
+
+// Delete removes the ID that was stored with the given digest. It returns
+// false if it wasn't stored.
+func (mi *MultiIndex) Delete(id string, digest blockhash.Digest) (found bool, err error) {
+	mi.lock.Lock()
+	defer mi.lock.Unlock()
+
+	err = mi.compatibility.check(digest)
+	if err != nil {
+		return false, err
+	}
+
+	if mi.size == 0 {
+		return false, nil
+	}
+
+	packed := newPackedDigest(digest)
+
+	entry := mi.find(id, packed)
+	if entry == nil {
+		return false, nil
+	}
+
+	for i, c := range mi.chunks {
+		key := packed.bitRange(c.start, c.length)
+
+		bucket := mi.tables[i][key]
+		for j, existing := range bucket {
+			if existing == entry {
+				bucket = append(bucket[:j], bucket[j+1:]...)
+				break
+			}
+		}
+
+		if len(bucket) == 0 {
+			delete(mi.tables[i], key)
+		} else {
+			mi.tables[i][key] = bucket
+		}
+	}
+
+	mi.size--
+
+	if mi.size == 0 {
+		mi.reset()
+	}
+
+	return true, nil
+}
+
+// Radius returns every stored digest that differs from the given one by no
+// more than `radius` bits, ordered by distance and then by ID.
+func (mi *MultiIndex) Radius(digest blockhash.Digest, radius int) (matches []Match, err error) {
+	mi.lock.RLock()
+	defer mi.lock.RUnlock()
+
+	err = mi.compatibility.check(digest)
+	if err != nil {
+		return nil, err
+	}
+
+	matches = mi.radius(newPackedDigest(digest), radius)
+	sortMatches(matches)
+
+	return matches, nil
+}
+
+func (mi *MultiIndex) radius(packed packedDigest, radius int) []Match {
+	matches := make([]Match, 0)
+
+	if mi.size == 0 || radius < 0 {
+		return matches
+	}
+
+	check := func(entry *mihEntry) {
+		distance := packed.distance(entry.packed)
+		if distance <= radius {
+			matches = append(matches, mi.match(entry, distance))
+		}
+	}
+
+	chunkRadii := mi.chunkRadii(radius)
+
+	// Looking up every nearby chunk value would take longer than checking
+	// everything.
+	if mi.lookupCount(chunkRadii) > mi.size {
+		for _, bucket := range mi.tables[0] {
+			for _, entry := range bucket {
+				check(entry)
+			}
+		}
+
+		return matches
+	}
+
+	seen := make(map[*mihEntry]struct{})
+
+	for i, c := range mi.chunks {
+		if chunkRadii[i] < 0 {
+			continue
+		}
+
+		key := packed.bitRange(c.start, c.length)
+
+		forEachNeighbour(key, c.length, chunkRadii[i], func(neighbour uint64) {
+			for _, entry := range mi.tables[i][neighbour] {
+				if _, found := seen[entry]; found == true {
+					continue
+				}
+
+				seen[entry] = struct{}{}
+				check(entry)
+			}
+		})
+	}
+
+	return matches
+}
+
+// chunkRadii returns how far each chunk has to be searched. For a radius of
+// (m * q + s) over `m` chunks, a match has to be within `q` bits in one of the
+// first (s + 1) chunks or within (q - 1) bits in one of the others; otherwise
+// it would differ by at least (m * q + s + 1) bits. A negative radius means
+// that the chunk doesn't have to be searched at all.
+func (mi *MultiIndex) chunkRadii(radius int) []int {
+	m := len(mi.chunks)
+	q := radius / m
+	s := radius % m
+
+	radii := make([]int, m)
+	for i := range radii {
+		if i <= s {
+			radii[i] = q
+		} else {
+			radii[i] = q - 1
+		}
+	}
+
+	return radii
+}
+
+// lookupCount returns the number of table lookups for the given chunk radii,
+// stopping once it exceeds the number of stored digests.
+func (mi *MultiIndex) lookupCount(chunkRadii []int) int {
+	total := 0
+
+	for i, c := range mi.chunks {
+		// Count the values within chunkRadii[i] bits: the sum of
+		// (length choose j) for every j up to the radius.
+
+		combinations := 1
+		for j := 1; j <= chunkRadii[i] && j <= c.length; j++ {
+			combinations = combinations * (c.length - j + 1) / j
+			total += combinations
+
+			if total > mi.size {
+				return total
+			}
+		}
+
+		if chunkRadii[i] >= 0 {
+			total++
+		}
+	}
+
+	return total
+}
+
+// forEachNeighbour calls `cb` with every value of `length` bits that differs
+// from `key` by no more than `radius` bits, including `key` itself.
+func forEachNeighbour(key uint64, length, radius int, cb func(neighbour uint64)) {
+	cb(key)
+
+	var flip func(value uint64, from, remaining int)
+	flip = func(value uint64, from, remaining int) {
+		if remaining == 0 {
+			return
+		}
+
+		for b := from; b < length; b++ {
+			flipped := value ^ (1 << uint(b))
+
+			cb(flipped)
+			flip(flipped, b+1, remaining-1)
+		}
+	}
+
+	flip(key, 0, radius)
+}
+
+// match rebuilds the digest of the entry for a result.
+func (mi *MultiIndex) match(entry *mihEntry, distance int) Match {
+	// We packed these bits ourselves, so they can't be invalid.
+	digest, err := blockhash.DigestFromWords(entry.algorithm, entry.packed, mi.columns, mi.rows)
+	log.PanicIf(err)
+
+	return Match{
+		ID:       entry.id,
+		Digest:   digest,
+		Distance: distance,
+	}
+}
+
+// Nearest returns the `k` stored digests that are closest to the given one,
+// ordered by distance and then by ID. The radius is widened a bit at a time
+// until there are enough matches, so this is only fast if the nearest digests
+// are close.
+func (mi *MultiIndex) Nearest(digest blockhash.Digest, k int) (matches []Match, err error) {
+	mi.lock.RLock()
+	defer mi.lock.RUnlock()
+
+	err = mi.compatibility.check(digest)
+	if err != nil {
+		return nil, err
+	}
+
+	if mi.size == 0 || k <= 0 {
+		return make([]Match, 0), nil
+	}
+
+	packed := newPackedDigest(digest)
+
+	for radius := 0; ; radius++ {
+		matches = mi.radius(packed, radius)
+
+		if len(matches) >= k || radius >= digest.Len() {
+			break
+		}
+	}
+
+	sortMatches(matches)
+
+	if len(matches) > k {
+		matches = matches[:k]
+	}
+
+	return matches, nil
+}
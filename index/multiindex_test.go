@@ -0,0 +1,328 @@
+package index
+
+import (
+	"fmt"
+	"math/rand"
+	"reflect"
+	"sync"
+	"testing"
+
+	"github.com/dsoprea/go-logging"
+
+	"github.com/dsoprea/go-perceptualhash"
+)
+
+func getTestMultiIndex(chunks int, entries []testEntry) *MultiIndex {
+	mi, err := NewMultiIndex(chunks)
+	log.PanicIf(err)
+
+	for _, entry := range entries {
+		err := mi.Insert(entry.id, entry.digest)
+		log.PanicIf(err)
+	}
+
+	return mi
+}
+
+func TestNewMultiIndex__InvalidChunks(t *testing.T) {
+	_, err := NewMultiIndex(-1)
+	if err != ErrInvalidChunks {
+		t.Fatalf("expected invalid-chunks error: %v", err)
+	}
+
+	r := rand.New(rand.NewSource(1))
+
+	// More chunks than bits.
+
+	mi, err := NewMultiIndex(65)
+	log.PanicIf(err)
+
+	err = mi.Insert("a", getTestDigest(r, 8))
+	if err != ErrInvalidChunks {
+		t.Fatalf("expected invalid-chunks error: %v", err)
+	}
+
+	// More than 64 bits in a chunk.
+
+	mi, err = NewMultiIndex(3)
+	log.PanicIf(err)
+
+	err = mi.Insert("a", getTestDigest(r, 16))
+	if err != ErrInvalidChunks {
+		t.Fatalf("expected invalid-chunks error: %v", err)
+	}
+}
+
+func TestMultiIndex_Radius(t *testing.T) {
+	r := rand.New(rand.NewSource(2))
+
+	for _, hashbits := range []int{8, 16} {
+		entries := getTestEntries(r, 30, 20, hashbits, hashbits*2)
+
+		for _, chunks := range []int{0, 4, 5, 7} {
+			mi := getTestMultiIndex(chunks, entries)
+
+			if mi.Len() != len(entries) {
+				t.Fatalf("size not correct: (%d)", mi.Len())
+			}
+
+			for i := 0; i < 20; i++ {
+				query := flipBits(r, entries[r.Intn(len(entries))].digest, r.Intn(6))
+
+				for _, radius := range []int{0, 1, 3, hashbits / 2, hashbits, hashbits * 2} {
+					actual, err := mi.Radius(query, radius)
+					log.PanicIf(err)
+
+					expected := bruteForceRadius(entries, query, radius)
+
+					if reflect.DeepEqual(matchIds(actual), matchIds(expected)) != true {
+						t.Fatalf("(%d) (%d) matches for radius (%d) not correct:\nactual: %v\nexpected: %v", hashbits, chunks, radius, matchIds(actual), matchIds(expected))
+					}
+				}
+			}
+		}
+	}
+}
+
+func TestMultiIndex_Radius__Digests(t *testing.T) {
+	r := rand.New(rand.NewSource(6))
+
+	var digest blockhash.Digest
+
+	err := digest.UnmarshalText([]byte("blockhash:16x16:" + getTestDigest(r, 16).String()))
+	log.PanicIf(err)
+
+	mi := getTestMultiIndex(0, []testEntry{{id: "a", digest: digest}})
+
+	matches, err := mi.Radius(digest, 0)
+	log.PanicIf(err)
+
+	if len(matches) != 1 {
+		t.Fatalf("expected one match: (%d)", len(matches))
+	}
+
+	// The digest is rebuilt from the packed bits, along with the algorithm.
+
+	if matches[0].Digest.Equal(digest) != true || matches[0].Digest.Algorithm() != blockhash.AlgorithmBlockhash {
+		t.Fatalf("digest not correct: [%s] [%s]", matches[0].Digest, matches[0].Digest.Algorithm())
+	}
+}
+
+func TestMultiIndex_Nearest(t *testing.T) {
+	r := rand.New(rand.NewSource(3))
+
+	entries := getTestEntries(r, 40, 10, 16, 20)
+	mi := getTestMultiIndex(0, entries)
+
+	for i := 0; i < 20; i++ {
+		query := flipBits(r, entries[r.Intn(len(entries))].digest, r.Intn(10))
+
+		for _, k := range []int{1, 5, 25} {
+			actual, err := mi.Nearest(query, k)
+			log.PanicIf(err)
+
+			expected := bruteForceNearest(entries, query, k)
+
+			if reflect.DeepEqual(matchIds(actual), matchIds(expected)) != true {
+				t.Fatalf("nearest (%d) not correct:\nactual: %v\nexpected: %v", k, matchIds(actual), matchIds(expected))
+			}
+		}
+	}
+
+	// Asking for more than there are returns everything.
+
+	actual, err := mi.Nearest(entries[0].digest, len(entries)+10)
+	log.PanicIf(err)
+
+	if len(actual) != len(entries) {
+		t.Fatalf("expected every entry: (%d)", len(actual))
+	}
+}
+
+func TestMultiIndex_Delete(t *testing.T) {
+	r := rand.New(rand.NewSource(4))
+
+	entries := getTestEntries(r, 20, 10, 16, 10)
+	mi := getTestMultiIndex(0, entries)
+
+	remaining := make([]testEntry, 0)
+	for i, entry := range entries {
+		if i%3 == 0 {
+			remaining = append(remaining, entry)
+			continue
+		}
+
+		found, err := mi.Delete(entry.id, entry.digest)
+		log.PanicIf(err)
+
+		if found != true {
+			t.Fatalf("entry not found: [%s]", entry.id)
+		}
+	}
+
+	if mi.Len() != len(remaining) {
+		t.Fatalf("size not correct after deleting: (%d) != (%d)", mi.Len(), len(remaining))
+	}
+
+	found, err := mi.Delete(entries[1].id, entries[1].digest)
+	log.PanicIf(err)
+
+	if found == true {
+		t.Fatalf("deleted entry found again")
+	}
+
+	for i := 0; i < 20; i++ {
+		query := flipBits(r, entries[r.Intn(len(entries))].digest, r.Intn(3))
+
+		actual, err := mi.Radius(query, 12)
+		log.PanicIf(err)
+
+		expected := bruteForceRadius(remaining, query, 12)
+
+		if reflect.DeepEqual(matchIds(actual), matchIds(expected)) != true {
+			t.Fatalf("matches after deleting not correct:\nactual: %v\nexpected: %v", matchIds(actual), matchIds(expected))
+		}
+	}
+
+	// Emptying the index allows digests of another size.
+
+	for _, entry := range remaining {
+		_, err := mi.Delete(entry.id, entry.digest)
+		log.PanicIf(err)
+	}
+
+	err = mi.Insert("other", getTestDigest(r, 8))
+	log.PanicIf(err)
+}
+
+func TestMultiIndex_Insert__Mismatch(t *testing.T) {
+	r := rand.New(rand.NewSource(5))
+
+	mi := getTestMultiIndex(0, []testEntry{{id: "a", digest: getTestDigest(r, 16)}})
+
+	err := mi.Insert("b", getTestDigest(r, 8))
+	if err != blockhash.ErrDigestSizeMismatch {
+		t.Fatalf("expected size-mismatch error: %v", err)
+	}
+}
+
+func TestForEachNeighbour(t *testing.T) {
+	seen := make(map[uint64]bool)
+
+	forEachNeighbour(0x5, 4, 2, func(neighbour uint64) {
+		if seen[neighbour] == true {
+			t.Fatalf("neighbour seen twice: (%x)", neighbour)
+		}
+
+		seen[neighbour] = true
+	})
+
+	// 1 + (4 choose 1) + (4 choose 2)
+	if len(seen) != 11 {
+		t.Fatalf("neighbour count not correct: (%d)", len(seen))
+	}
+}
+
+const (
+	benchmarkEntryCount = 1000000
+)
+
+var (
+	benchmarkMultiIndex     *MultiIndex
+	benchmarkPacked         []packedDigest
+	benchmarkQueries        []blockhash.Digest
+	benchmarkMultiIndexOnce sync.Once
+)
+
+// getBenchmarkMultiIndex builds an index of a million random 256-bit digests
+// once for all of the benchmarks, along with queries that are a few bits away
+// from stored digests.
+func getBenchmarkMultiIndex(b *testing.B) *MultiIndex {
+	if testing.Short() == true {
+		b.Skip("building a million entries takes a while")
+	}
+
+	benchmarkMultiIndexOnce.Do(func() {
+		r := rand.New(rand.NewSource(1))
+
+		mi, err := NewMultiIndex(0)
+		log.PanicIf(err)
+
+		err = mi.setup(getTestDigest(r, 16))
+		log.PanicIf(err)
+
+		benchmarkPacked = make([]packedDigest, benchmarkEntryCount)
+		for i := range benchmarkPacked {
+			packed := make(packedDigest, 4)
+			for j := range packed {
+				packed[j] = r.Uint64()
+			}
+
+			benchmarkPacked[i] = packed
+			mi.insert(fmt.Sprintf("%d", i), blockhash.AlgorithmBlockhash, packed)
+		}
+
+		benchmarkQueries = make([]blockhash.Digest, 100)
+		for i := range benchmarkQueries {
+			stored := mi.match(&mihEntry{packed: benchmarkPacked[r.Intn(len(benchmarkPacked))]}, 0).Digest
+			benchmarkQueries[i] = flipBits(r, stored, r.Intn(10))
+		}
+
+		benchmarkMultiIndex = mi
+	})
+
+	b.ResetTimer()
+
+	return benchmarkMultiIndex
+}
+
+func benchmarkMultiIndexRadius(b *testing.B, radius int) {
+	mi := getBenchmarkMultiIndex(b)
+
+	for i := 0; i < b.N; i++ {
+		_, err := mi.Radius(benchmarkQueries[i%len(benchmarkQueries)], radius)
+		log.PanicIf(err)
+	}
+}
+
+func benchmarkLinearScan(b *testing.B, radius int) {
+	getBenchmarkMultiIndex(b)
+
+	queries := make([]packedDigest, len(benchmarkQueries))
+	for i, query := range benchmarkQueries {
+		queries[i] = newPackedDigest(query)
+	}
+
+	b.ResetTimer()
+
+	for i := 0; i < b.N; i++ {
+		query := queries[i%len(queries)]
+
+		matches := 0
+		for _, packed := range benchmarkPacked {
+			if query.distance(packed) <= radius {
+				matches++
+			}
+		}
+
+		if matches == 0 {
+			b.Fatalf("expected at least one match")
+		}
+	}
+}
+
+func BenchmarkMultiIndex_Radius__1M_10(b *testing.B) {
+	benchmarkMultiIndexRadius(b, 10)
+}
+
+func BenchmarkMultiIndex_Radius__1M_20(b *testing.B) {
+	benchmarkMultiIndexRadius(b, 20)
+}
+
+func BenchmarkLinearScan__1M_10(b *testing.B) {
+	benchmarkLinearScan(b, 10)
+}
+
+func BenchmarkLinearScan__1M_20(b *testing.B) {
+	benchmarkLinearScan(b, 20)
+}
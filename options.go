@@ -0,0 +1,94 @@
+package blockhash
+
+const (
+	// DefaultHashbits is the blockhash grid size used when none is given.
+	DefaultHashbits = 16
+)
+
+// Method selects how pixels are aggregated into blocks.
+type Method int
+
+const (
+	// MethodPrecise weights pixels that straddle block boundaries across the
+	// neighbouring blocks. This is method 2 of the reference implementation.
+	MethodPrecise Method = iota
+
+	// MethodQuick assigns whole pixels to non-overlapping blocks and ignores
+	// any remainder at the right and bottom edges. This is method 1 of the
+	// reference implementation and is considerably faster for large images.
+	MethodQuick
+)
+
+// Option configures how a hash is calculated.
+type Option func(o *options)
+
+type options struct {
+	columns int
+	rows    int
+	method  Method
+	level   int
+}
+
+func newOptions(defaultHashbits int, opts []Option) *options {
+	o := &options{
+		columns: defaultHashbits,
+		rows:    defaultHashbits,
+		method:  MethodPrecise,
+		level:   DefaultWHashLevel,
+	}
+
+	for _, opt := range opts {
+		opt(o)
+	}
+
+	return o
+}
+
+// validate returns ErrInvalidHashbits or ErrInvalidMethod if the options can
+// not be used.
+func (o *options) validate() (err error) {
+	if o.columns <= 0 || o.rows <= 0 {
+		return ErrInvalidHashbits
+	}
+
+	if o.method != MethodPrecise && o.method != MethodQuick {
+		return ErrInvalidMethod
+	}
+
+	return nil
+}
+
+// WithHashbits sets the size of a square grid. The digest will have
+// (hashbits^2) bits.
+func WithHashbits(hashbits int) Option {
+	return func(o *options) {
+		o.columns = hashbits
+		o.rows = hashbits
+	}
+}
+
+// WithGridSize sets the number of columns and rows in the grid separately,
+// which suits very wide or very tall images. The digest will have
+// (columns * rows) bits. WithGridSize(n, n) is the same as WithHashbits(n).
+func WithGridSize(columns, rows int) Option {
+	return func(o *options) {
+		o.columns = columns
+		o.rows = rows
+	}
+}
+
+// WithMethod sets the block aggregation method. The default is MethodPrecise.
+// It is ignored by pHash, which downscales the image with a Lanczos filter.
+func WithMethod(method Method) Option {
+	return func(o *options) {
+		o.method = method
+	}
+}
+
+// WithLevel sets the number of wavelet decompositions for wHash. The default
+// is DefaultWHashLevel. It is ignored by the other algorithms.
+func WithLevel(level int) Option {
+	return func(o *options) {
+		o.level = level
+	}
+}
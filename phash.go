@@ -0,0 +1,192 @@
+package blockhash
+
+import (
+	"context"
+	"image"
+	"math"
+
+	"github.com/dsoprea/go-logging"
+)
+
+const (
+	// DefaultPHashHashbits is the pHash grid size used when none is given.
+	DefaultPHashHashbits = 8
+
+	// pHashOversampling is how many times larger the downscaled image is than
+	// the grid of low frequencies that are kept.
+	pHashOversampling = 4
+)
+
+// PHash calculates DCT-based perceptual hashes (pHash). The image is converted
+// to grayscale and downscaled to four times the size of the hash, transformed
+// with a 2D discrete cosine transform, and the lowest (columns x rows)
+// frequencies are compared against their median. This is much more tolerant
+// of gamma and contrast changes than blockhash.
+//
+// This follows imagehash.phash(). The image is converted to luma and
+// downscaled with a Lanczos filter the same way as Pillow does, so that
+// square digests of PNG images match imagehash's. The method (see
+// WithMethod()) doesn't apply.
+type PHash struct {
+	columns int
+	rows    int
+
+	// rowCosines and columnCosines are the DCT tables for the rows and
+	// columns of the downscaled image, which are only calculated once. They
+	// are the same table if the grid is square.
+	rowCosines    []float64
+	columnCosines []float64
+}
+
+// NewPHash returns a pHash hasher. The grid size defaults to
+// DefaultPHashHashbits.
+func NewPHash(opts ...Option) (ph *PHash, err error) {
+	o := newOptions(DefaultPHashHashbits, opts)
+
+	err = o.validate()
+	if err != nil {
+		return nil, err
+	}
+
+	rowCosines := dctCosines(o.columns * pHashOversampling)
+
+	columnCosines := rowCosines
+	if o.rows != o.columns {
+		columnCosines = dctCosines(o.rows * pHashOversampling)
+	}
+
+	ph = &PHash{
+		columns:       o.columns,
+		rows:          o.rows,
+		rowCosines:    rowCosines,
+		columnCosines: columnCosines,
+	}
+
+	return ph, nil
+}
+
+// Hash calculates the digest of the given image.
+func (ph *PHash) Hash(img image.Image) (digest Digest, err error) {
+	return ph.HashContext(context.Background(), img)
+}
+
+// HashContext calculates the digest of the given image. The context's error is
+// returned if it is canceled while the image is being read.
+func (ph *PHash) HashContext(ctx context.Context, img image.Image) (digest Digest, err error) {
+	defer func() {
+		if state := recover(); state != nil {
+			err = log.Wrap(state.(error))
+		}
+	}()
+
+	columns := ph.columns * pHashOversampling
+	rows := ph.rows * pHashOversampling
+
+	err = validateImage(img, columns, rows)
+	if err != nil {
+		return Digest{}, err
+	}
+
+	pixels, err := resizeLuma(ctx, newPixelReader(img), columns, rows)
+	if err != nil {
+		return Digest{}, err
+	}
+
+	values := make([]float64, len(pixels))
+	for i, v := range pixels {
+		values[i] = float64(v)
+	}
+
+	bits := pHashBits(values, columns, rows, ph.columns, ph.rows, ph.rowCosines, ph.columnCosines)
+
+	return newDigest(AlgorithmPHash, bits, ph.columns, ph.rows), nil
+}
+
+// pHashBits transforms the (columns x rows) values and compares the top-left
+// (hashColumns x hashRows) coefficients to their median.
+func pHashBits(values []float64, columns, rows, hashColumns, hashRows int, rowCosines, columnCosines []float64) []int {
+	coefficients := dct2d(values, columns, rows, rowCosines, columnCosines)
+
+	lowFrequencies := make([]float64, hashColumns*hashRows)
+	for y := 0; y < hashRows; y++ {
+		copy(lowFrequencies[y*hashColumns:(y+1)*hashColumns], coefficients[y*columns:y*columns+hashColumns])
+	}
+
+	m := median(lowFrequencies)
+
+	bits := make([]int, len(lowFrequencies))
+	for i, v := range lowFrequencies {
+		if v > m {
+			bits[i] = 1
+		}
+	}
+
+	return bits
+}
+
+// dct2d applies a type-II discrete cosine transform along both axes of the
+// values, which are stored row by row. The transform is not normalized, so
+// the results match `scipy.fftpack.dct()` with the default arguments.
+// `rowCosines` and `columnCosines` are the tables from dctCosines() for the
+// length of a row and a column, respectively.
+func dct2d(values []float64, columns, rows int, rowCosines, columnCosines []float64) []float64 {
+	transformed := make([]float64, len(values))
+
+	// Transform each row.
+
+	in := make([]float64, columns)
+	out := make([]float64, columns)
+
+	for y := 0; y < rows; y++ {
+		copy(in, values[y*columns:(y+1)*columns])
+		dct1d(in, out, rowCosines)
+		copy(transformed[y*columns:(y+1)*columns], out)
+	}
+
+	// Transform each column.
+
+	in = make([]float64, rows)
+	out = make([]float64, rows)
+
+	for x := 0; x < columns; x++ {
+		for y := 0; y < rows; y++ {
+			in[y] = transformed[y*columns+x]
+		}
+
+		dct1d(in, out, columnCosines)
+
+		for y := 0; y < rows; y++ {
+			transformed[y*columns+x] = out[y]
+		}
+	}
+
+	return transformed
+}
+
+// dctCosines returns the table of cos(pi * k * (2n + 1) / 2N) for a transform
+// of length N, indexed by (k * N + n).
+func dctCosines(n int) []float64 {
+	cosines := make([]float64, n*n)
+
+	for k := 0; k < n; k++ {
+		for i := 0; i < n; i++ {
+			cosines[k*n+i] = math.Cos(math.Pi * float64(k) * float64(2*i+1) / float64(2*n))
+		}
+	}
+
+	return cosines
+}
+
+// dct1d applies an unnormalized type-II discrete cosine transform.
+func dct1d(in, out []float64, cosines []float64) {
+	n := len(in)
+
+	for k := 0; k < n; k++ {
+		sum := 0.0
+		for i, v := range in {
+			sum += v * cosines[k*n+i]
+		}
+
+		out[k] = 2.0 * sum
+	}
+}
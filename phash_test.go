@@ -0,0 +1,235 @@
+package blockhash
+
+import (
+	"image"
+	"image/color"
+	"math"
+	"testing"
+
+	"github.com/dsoprea/go-logging"
+)
+
+func TestDct2d(t *testing.T) {
+	values := []float64{
+		1, 2, 3, 4,
+		5, 6, 7, 8,
+		9, 10, 11, 12,
+		13, 14, 15, 17,
+	}
+
+	// Calculated as `scipy.fftpack.dct(scipy.fftpack.dct(values, axis=0),
+	// axis=1)`.
+	expected := []float64{
+		548.0000000000, -54.1646706084, 2.8284271247, -5.1174659628,
+		-205.5721280436, 3.4142135624, -2.6131259298, 1.4142135624,
+		2.8284271247, -2.6131259298, 2.0000000000, -1.0823922003,
+		-15.8776626628, 1.4142135624, -1.0823922003, 0.5857864376,
+	}
+
+	actual := dct2d(values, 4, 4, dctCosines(4), dctCosines(4))
+
+	for i, v := range expected {
+		if math.Abs(actual[i]-v) > 1e-9 {
+			t.Fatalf("coefficient (%d) not correct: (%.10f) != (%.10f)", i, actual[i], v)
+		}
+	}
+}
+
+func TestDct2d__Constant(t *testing.T) {
+	values := make([]float64, 8*8)
+	for i := range values {
+		values[i] = 10.0
+	}
+
+	actual := dct2d(values, 8, 8, dctCosines(8), dctCosines(8))
+
+	// Only the DC coefficient is non-zero: (2 * 8) * (2 * 8) * 10.
+	if math.Abs(actual[0]-2560.0) > 1e-9 {
+		t.Fatalf("DC coefficient not correct: (%f)", actual[0])
+	}
+
+	for i, v := range actual[1:] {
+		if math.Abs(v) > 1e-9 {
+			t.Fatalf("AC coefficient (%d) not zero: (%f)", i+1, v)
+		}
+	}
+}
+
+// TestPHashBits checks the bits against the same inputs run through the
+// transform and median of `imagehash.phash()` (32x32 input, 8x8 low
+// frequencies, median of `scipy.fftpack.dct()` coefficients including DC).
+func TestPHashBits(t *testing.T) {
+	values := make([]float64, 32*32)
+	for y := 0; y < 32; y++ {
+		for x := 0; x < 32; x++ {
+			values[y*32+x] = float64((x*x*3 + y*17 + (x^y)*5) % 256)
+		}
+	}
+
+	bits := pHashBits(values, 32, 32, 8, 8, dctCosines(32), dctCosines(32))
+
+	digest := newDigest(AlgorithmPHash, bits, 8, 8)
+	if digest.String() != "b593c0690001ffff" {
+		t.Fatalf("bits not correct: [%s]", digest)
+	}
+
+	for y := 0; y < 32; y++ {
+		for x := 0; x < 32; x++ {
+			values[y*32+x] = 128.0 + 100.0*math.Sin(float64(x)/5.0)*math.Cos(float64(y)/7.0)
+		}
+	}
+
+	bits = pHashBits(values, 32, 32, 8, 8, dctCosines(32), dctCosines(32))
+
+	digest = newDigest(AlgorithmPHash, bits, 8, 8)
+	if digest.String() != "b54a4ab54ab54ab5" {
+		t.Fatalf("bits not correct: [%s]", digest)
+	}
+}
+
+func TestPHashBits__NonSquare(t *testing.T) {
+	// Transposing the input has to transpose the bits.
+
+	columns, rows := 32, 16
+
+	values := make([]float64, columns*rows)
+	transposed := make([]float64, columns*rows)
+
+	for y := 0; y < rows; y++ {
+		for x := 0; x < columns; x++ {
+			v := float64((x*x*3 + y*17 + (x^y)*5) % 256)
+
+			values[y*columns+x] = v
+			transposed[x*rows+y] = v
+		}
+	}
+
+	bits := pHashBits(values, columns, rows, 8, 4, dctCosines(columns), dctCosines(rows))
+	transposedBits := pHashBits(transposed, rows, columns, 4, 8, dctCosines(rows), dctCosines(columns))
+
+	for y := 0; y < 4; y++ {
+		for x := 0; x < 8; x++ {
+			if bits[y*8+x] != transposedBits[x*4+y] {
+				t.Fatalf("bit (%d, %d) not transposed", x, y)
+			}
+		}
+	}
+}
+
+func TestNewPHash(t *testing.T) {
+	ph, err := NewPHash()
+	log.PanicIf(err)
+
+	if ph.columns != DefaultPHashHashbits || ph.rows != DefaultPHashHashbits {
+		t.Fatalf("default hashbits not applied: (%d) (%d)", ph.columns, ph.rows)
+	}
+
+	_, err = NewPHash(WithHashbits(0))
+	if err != ErrInvalidHashbits {
+		t.Fatalf("expected invalid-hashbits error: %v", err)
+	}
+}
+
+func TestPHash_Hash(t *testing.T) {
+	f, i := getTestImage(testImagePng1Small)
+	defer f.Close()
+
+	ph, err := NewPHash()
+	log.PanicIf(err)
+
+	digest, err := ph.Hash(i)
+	log.PanicIf(err)
+
+	if digest.Hashbits() != 8 || digest.Len() != 64 {
+		t.Fatalf("digest not the right size: (%d) (%d)", digest.Hashbits(), digest.Len())
+	}
+
+	// Hashing again has to give the same result.
+
+	again, err := ph.Hash(i)
+	log.PanicIf(err)
+
+	if again.Equal(digest) != true {
+		t.Fatalf("digest not stable: [%s] != [%s]", again, digest)
+	}
+}
+
+func TestPHash_Hash__Reference(t *testing.T) {
+	// Calculated by a transcription of `imagehash.phash(image, hash_size)`,
+	// including Pillow's `convert("L")` and Lanczos `resize()`.
+	cases := []struct {
+		filename string
+		hashbits int
+		expected string
+	}{
+		{testImagePng1Small, 8, "84b1b357cd477330"},
+		{testImagePng1Small, 16, "843bb055b3c557e3cd78451d328730e25b7c5d1764c1e64cf334392a1cfa949d"},
+		{testImagePng1SmallAlpha, 8, "84b1f357cd463730"},
+		{testImagePng1SmallAlpha, 16, "8431b055b3c557e3cd7a441d338730e05b7c5d9765c1e64cf334396a1cfa9487"},
+		{testImagePng1SmallEven, 16, "843bb055b3c557e3cd78451d328730e25b7c5d1764c1e64cf234392a1cfa9c9d"},
+	}
+
+	for _, c := range cases {
+		f, i := getTestImage(c.filename)
+		f.Close()
+
+		ph, err := NewPHash(WithHashbits(c.hashbits))
+		log.PanicIf(err)
+
+		digest, err := ph.Hash(i)
+		log.PanicIf(err)
+
+		if digest.String() != c.expected {
+			t.Fatalf("%s (%d): digest not correct: [%s] != [%s]", c.filename, c.hashbits, digest, c.expected)
+		}
+	}
+}
+
+func TestPHash_Hash__Gamma(t *testing.T) {
+	f, i := getTestImage(testImagePng1Small)
+	defer f.Close()
+
+	// Apply a strong gamma curve to the image.
+
+	r := i.Bounds()
+	adjusted := image.NewNRGBA(r)
+
+	gamma := func(v uint8) uint8 {
+		return uint8(math.Pow(float64(v)/255.0, 0.5) * 255.0)
+	}
+
+	for y := r.Min.Y; y < r.Max.Y; y++ {
+		for x := r.Min.X; x < r.Max.X; x++ {
+			c := color.NRGBAModel.Convert(i.At(x, y)).(color.NRGBA)
+			adjusted.SetNRGBA(x, y, color.NRGBA{R: gamma(c.R), G: gamma(c.G), B: gamma(c.B), A: c.A})
+		}
+	}
+
+	ph, err := NewPHash()
+	log.PanicIf(err)
+
+	original, err := ph.Hash(i)
+	log.PanicIf(err)
+
+	gammaDigest, err := ph.Hash(adjusted)
+	log.PanicIf(err)
+
+	distance, err := original.Distance(gammaDigest)
+	log.PanicIf(err)
+
+	if distance > 6 {
+		t.Fatalf("gamma-adjusted digest too far from original: (%d)", distance)
+	}
+}
+
+func TestPHash_Hash__ImageTooSmall(t *testing.T) {
+	ph, err := NewPHash()
+	log.PanicIf(err)
+
+	i := image.NewGray(image.Rect(0, 0, 31, 64))
+
+	_, err = ph.Hash(i)
+	if err != ErrImageTooSmall {
+		t.Fatalf("expected image-too-small error: %v", err)
+	}
+}
@@ -0,0 +1,218 @@
+package blockhash
+
+import (
+	"image"
+	"image/color"
+)
+
+const (
+	// transparentValue is the value given to fully-transparent pixels (the
+	// same as white).
+	transparentValue = 765
+)
+
+// opaqueableModel automatically fulfilled by existing Go types.
+type opaqueableModel interface {
+	Opaque() bool
+}
+
+// pixelReader reads the summed (R + G + B) value or the luma of pixels. The
+// common concrete image types are read directly from their pixel buffers;
+// everything else goes through `At()` and a color conversion. Both produce the
+// same values.
+type pixelReader struct {
+	image        image.Image
+	bounds       image.Rectangle
+	isOpaqueable bool
+
+	// palette has the precalculated value of each palette entry when the image
+	// is paletted.
+	palette []uint32
+}
+
+func newPixelReader(img image.Image) *pixelReader {
+	// Only images that support alpha are explicitly aware of opaqueness.
+	_, isOpaqueable := img.(opaqueableModel)
+
+	pr := &pixelReader{
+		image:        img,
+		bounds:       img.Bounds(),
+		isOpaqueable: isOpaqueable,
+	}
+
+	if paletted, ok := img.(*image.Paletted); ok == true {
+		pr.palette = make([]uint32, len(paletted.Palette))
+		for i, c := range paletted.Palette {
+			pr.palette[i] = pr.totalValue(c)
+		}
+	}
+
+	return pr
+}
+
+// size returns the dimensions of the image.
+func (pr *pixelReader) size() (width int, height int) {
+	return pr.bounds.Dx(), pr.bounds.Dy()
+}
+
+func (pr *pixelReader) totalValue(p color.Color) (value uint32) {
+	// The RGBA() will return the alpha-multiplied values but the fields will
+	// still be in their premultiplied state. Anything that doesn't already
+	// carry its own 8-bit fields is converted to non-premultiplied form, which
+	// is what the reference implementation reads.
+	var r, g, b, a uint8
+
+	switch c := p.(type) {
+	case color.RGBA:
+		r, g, b, a = c.R, c.G, c.B, c.A
+	case color.NRGBA:
+		r, g, b, a = c.R, c.G, c.B, c.A
+	default:
+		c2 := color.NRGBAModel.Convert(p).(color.NRGBA)
+		r, g, b, a = c2.R, c2.G, c2.B, c2.A
+	}
+
+	if pr.isOpaqueable == true && a == 0 {
+		return transparentValue
+	}
+
+	return uint32(r) + uint32(g) + uint32(b)
+}
+
+// totalValueAt returns the value of the pixel at the given position, relative
+// to the top-left corner of the image bounds (which isn't necessarily (0, 0)).
+func (pr *pixelReader) totalValueAt(x, y int) (value uint32) {
+	p := pr.image.At(pr.bounds.Min.X+x, pr.bounds.Min.Y+y)
+
+	return pr.totalValue(p)
+}
+
+// readRow fills `row` with the values of the pixels in row `y` (relative to
+// the top of the image bounds). `row` must be as long as the image is wide.
+func (pr *pixelReader) readRow(y int, row []uint32) {
+	minX := pr.bounds.Min.X
+	imageY := pr.bounds.Min.Y + y
+
+	switch img := pr.image.(type) {
+	case *image.RGBA:
+		i := img.PixOffset(minX, imageY)
+		for x := range row {
+			pix := img.Pix[i : i+4 : i+4]
+			if pix[3] == 0 {
+				row[x] = transparentValue
+			} else {
+				row[x] = uint32(pix[0]) + uint32(pix[1]) + uint32(pix[2])
+			}
+
+			i += 4
+		}
+
+	case *image.NRGBA:
+		i := img.PixOffset(minX, imageY)
+		for x := range row {
+			pix := img.Pix[i : i+4 : i+4]
+			if pix[3] == 0 {
+				row[x] = transparentValue
+			} else {
+				row[x] = uint32(pix[0]) + uint32(pix[1]) + uint32(pix[2])
+			}
+
+			i += 4
+		}
+
+	case *image.YCbCr:
+		for x := range row {
+			yi := img.YOffset(minX+x, imageY)
+			ci := img.COffset(minX+x, imageY)
+
+			// Use the same conversion as `At()` so that the values are
+			// identical to the general path.
+			c := color.YCbCr{Y: img.Y[yi], Cb: img.Cb[ci], Cr: img.Cr[ci]}
+			r, g, b, _ := c.RGBA()
+
+			row[x] = (r >> 8) + (g >> 8) + (b >> 8)
+		}
+
+	case *image.Gray:
+		i := img.PixOffset(minX, imageY)
+		for x := range row {
+			row[x] = uint32(img.Pix[i+x]) * 3
+		}
+
+	case *image.Paletted:
+		i := img.PixOffset(minX, imageY)
+		for x := range row {
+			row[x] = pr.palette[img.Pix[i+x]]
+		}
+
+	default:
+		for x := range row {
+			row[x] = pr.totalValueAt(x, y)
+		}
+	}
+}
+
+// luma returns the luma of the color the same way as Pillow's `convert("L")`
+// (ITU-R 601-2, rounded).
+func luma(r, g, b uint8) uint8 {
+	return uint8((uint32(r)*19595 + uint32(g)*38470 + uint32(b)*7471 + 0x8000) >> 16)
+}
+
+// lumaValue returns the luma of the color. Alpha is ignored, as Pillow does.
+func lumaValue(p color.Color) uint8 {
+	c := color.NRGBAModel.Convert(p).(color.NRGBA)
+	return luma(c.R, c.G, c.B)
+}
+
+// readLumaRow fills `row` with the luma of the pixels in row `y` (relative to
+// the top of the image bounds). `row` must be as long as the image is wide.
+func (pr *pixelReader) readLumaRow(y int, row []uint8) {
+	minX := pr.bounds.Min.X
+	imageY := pr.bounds.Min.Y + y
+
+	switch img := pr.image.(type) {
+	case *image.RGBA:
+		i := img.PixOffset(minX, imageY)
+		for x := range row {
+			pix := img.Pix[i : i+4 : i+4]
+			if pix[3] == 0xff {
+				row[x] = luma(pix[0], pix[1], pix[2])
+			} else {
+				row[x] = lumaValue(color.RGBA{R: pix[0], G: pix[1], B: pix[2], A: pix[3]})
+			}
+
+			i += 4
+		}
+
+	case *image.NRGBA:
+		i := img.PixOffset(minX, imageY)
+		for x := range row {
+			pix := img.Pix[i : i+4 : i+4]
+			row[x] = luma(pix[0], pix[1], pix[2])
+
+			i += 4
+		}
+
+	case *image.YCbCr:
+		for x := range row {
+			yi := img.YOffset(minX+x, imageY)
+			ci := img.COffset(minX+x, imageY)
+
+			// Use the same conversion as `At()` so that the values are
+			// identical to the general path.
+			c := color.YCbCr{Y: img.Y[yi], Cb: img.Cb[ci], Cr: img.Cr[ci]}
+			r, g, b, _ := c.RGBA()
+
+			row[x] = luma(uint8(r>>8), uint8(g>>8), uint8(b>>8))
+		}
+
+	case *image.Gray:
+		i := img.PixOffset(minX, imageY)
+		copy(row, img.Pix[i:i+len(row)])
+
+	default:
+		for x := range row {
+			row[x] = lumaValue(pr.image.At(minX+x, imageY))
+		}
+	}
+}
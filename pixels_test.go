@@ -0,0 +1,248 @@
+package blockhash
+
+import (
+	"image"
+	"image/color"
+	"image/color/palette"
+	"image/draw"
+	"testing"
+
+	"github.com/dsoprea/go-logging"
+)
+
+// genericImage hides the concrete type of an image so that only the general
+// `At()` path can be used to read it.
+type genericImage struct {
+	image.Image
+}
+
+func (gi genericImage) Opaque() bool {
+	return gi.Image.(opaqueableModel).Opaque()
+}
+
+type subImager interface {
+	SubImage(r image.Rectangle) image.Image
+}
+
+func getTestFastPathImages() map[string]image.Image {
+	f, smallAlpha := getTestImage(testImagePng1SmallAlpha)
+	f.Close()
+
+	f, big := getTestImage(testImageJpeg1Big)
+	f.Close()
+
+	f, grayscale := getTestImage(testImagePng1BigGrayscale)
+	f.Close()
+
+	r := smallAlpha.Bounds()
+
+	rgba := image.NewRGBA(r)
+	draw.Draw(rgba, r, smallAlpha, r.Min, draw.Src)
+
+	// Include a fully-transparent entry so that it gets used for the
+	// transparent pixels.
+	paletteWithAlpha := append(color.Palette{color.NRGBA{}}, palette.WebSafe...)
+
+	paletted := image.NewPaletted(r, paletteWithAlpha)
+	draw.Draw(paletted, r, smallAlpha, r.Min, draw.Src)
+
+	// Use odd crops of the large images so that the offsets into their pixel
+	// buffers are exercised.
+	crop := image.Rect(1001, 503, 1001+317, 503+211)
+
+	images := map[string]image.Image{
+		"RGBA":     rgba,
+		"NRGBA":    smallAlpha,
+		"YCbCr":    big.(subImager).SubImage(crop),
+		"Gray":     grayscale.(subImager).SubImage(crop),
+		"Paletted": paletted,
+	}
+
+	return images
+}
+
+func TestPixelReader_ReadRow(t *testing.T) {
+	images := getTestFastPathImages()
+
+	for name, i := range images {
+		pr := newPixelReader(i)
+		width, height := pr.size()
+
+		row := make([]uint32, width)
+		for y := 0; y < height; y++ {
+			pr.readRow(y, row)
+
+			for x := 0; x < width; x++ {
+				expected := pr.totalValueAt(x, y)
+				if row[x] != expected {
+					t.Fatalf("%s: value at (%d, %d) not correct: (%d) != (%d)", name, x, y, row[x], expected)
+				}
+			}
+		}
+	}
+}
+
+func TestPixelReader_ReadLumaRow(t *testing.T) {
+	images := getTestFastPathImages()
+
+	for name, i := range images {
+		pr := newPixelReader(i)
+		width, height := pr.size()
+
+		bounds := i.Bounds()
+
+		row := make([]uint8, width)
+		for y := 0; y < height; y++ {
+			pr.readLumaRow(y, row)
+
+			for x := 0; x < width; x++ {
+				expected := lumaValue(i.At(bounds.Min.X+x, bounds.Min.Y+y))
+				if row[x] != expected {
+					t.Fatalf("%s: luma at (%d, %d) not correct: (%d) != (%d)", name, x, y, row[x], expected)
+				}
+			}
+		}
+	}
+}
+
+func TestLuma(t *testing.T) {
+	// The same values as Pillow's `convert("L")`.
+	cases := []struct {
+		c        color.NRGBA
+		expected uint8
+	}{
+		{color.NRGBA{R: 255, G: 255, B: 255, A: 255}, 255},
+		{color.NRGBA{R: 255, A: 255}, 76},
+		{color.NRGBA{G: 255, A: 255}, 150},
+		{color.NRGBA{B: 255, A: 255}, 29},
+		{color.NRGBA{R: 100, G: 100, B: 100, A: 255}, 100},
+		{color.NRGBA{R: 10, G: 200, B: 30, A: 0}, 124},
+	}
+
+	for _, c := range cases {
+		if v := lumaValue(c.c); v != c.expected {
+			t.Fatalf("luma of %v not correct: (%d) != (%d)", c.c, v, c.expected)
+		}
+	}
+}
+
+func TestPixelReader_ReadRow__FastPathDigests(t *testing.T) {
+	images := getTestFastPathImages()
+
+	for name, i := range images {
+		for _, method := range []Method{MethodPrecise, MethodQuick} {
+			bh, err := New(i, WithMethod(method))
+			log.PanicIf(err)
+
+			fast, err := bh.Digest()
+			log.PanicIf(err)
+
+			bh, err = New(genericImage{i}, WithMethod(method))
+			log.PanicIf(err)
+
+			generic, err := bh.Digest()
+			log.PanicIf(err)
+
+			if fast.Equal(generic) != true {
+				t.Fatalf("%s: fast-path digest does not match generic digest: [%s] != [%s]", name, fast, generic)
+			}
+		}
+	}
+}
+
+func TestPixelReader_ReadRow__Generic(t *testing.T) {
+	_, i := getTestImage(testImagePng1Small)
+
+	pr := newPixelReader(genericImage{i})
+	width, _ := pr.size()
+
+	row := make([]uint32, width)
+	pr.readRow(10, row)
+
+	for x := 0; x < width; x++ {
+		if row[x] != pr.totalValueAt(x, 10) {
+			t.Fatalf("value at (%d) not correct", x)
+		}
+	}
+}
+
+func benchmarkHash(b *testing.B, i image.Image) {
+	for n := 0; n < b.N; n++ {
+		bh, err := New(i)
+		log.PanicIf(err)
+
+		_, err = bh.Digest()
+		log.PanicIf(err)
+	}
+}
+
+func getBenchmarkImage(b *testing.B, name string) image.Image {
+	f, big := getTestImage(testImageJpeg1Big)
+	f.Close()
+
+	r := big.Bounds()
+
+	var i draw.Image
+
+	switch name {
+	case "RGBA":
+		i = image.NewRGBA(r)
+	case "NRGBA":
+		i = image.NewNRGBA(r)
+	case "Gray":
+		i = image.NewGray(r)
+	case "Paletted":
+		i = image.NewPaletted(r, palette.Plan9)
+	case "YCbCr":
+		b.ResetTimer()
+		return big
+	default:
+		log.Panicf("benchmark image type not valid: [%s]", name)
+	}
+
+	draw.Draw(i, r, big, r.Min, draw.Src)
+
+	b.ResetTimer()
+
+	return i
+}
+
+func BenchmarkHash__RGBA(b *testing.B) {
+	benchmarkHash(b, getBenchmarkImage(b, "RGBA"))
+}
+
+func BenchmarkHash__RGBA_Generic(b *testing.B) {
+	benchmarkHash(b, genericImage{getBenchmarkImage(b, "RGBA")})
+}
+
+func BenchmarkHash__NRGBA(b *testing.B) {
+	benchmarkHash(b, getBenchmarkImage(b, "NRGBA"))
+}
+
+func BenchmarkHash__NRGBA_Generic(b *testing.B) {
+	benchmarkHash(b, genericImage{getBenchmarkImage(b, "NRGBA")})
+}
+
+func BenchmarkHash__YCbCr(b *testing.B) {
+	benchmarkHash(b, getBenchmarkImage(b, "YCbCr"))
+}
+
+func BenchmarkHash__YCbCr_Generic(b *testing.B) {
+	benchmarkHash(b, genericImage{getBenchmarkImage(b, "YCbCr")})
+}
+
+func BenchmarkHash__Gray(b *testing.B) {
+	benchmarkHash(b, getBenchmarkImage(b, "Gray"))
+}
+
+func BenchmarkHash__Gray_Generic(b *testing.B) {
+	benchmarkHash(b, genericImage{getBenchmarkImage(b, "Gray")})
+}
+
+func BenchmarkHash__Paletted(b *testing.B) {
+	benchmarkHash(b, getBenchmarkImage(b, "Paletted"))
+}
+
+func BenchmarkHash__Paletted_Generic(b *testing.B) {
+	benchmarkHash(b, genericImage{getBenchmarkImage(b, "Paletted")})
+}
@@ -0,0 +1,184 @@
+package blockhash
+
+import (
+	"context"
+	"math"
+)
+
+const (
+	// lanczosSupport is the radius of the Lanczos filter in output pixels.
+	lanczosSupport = 3.0
+
+	// resamplePrecisionBits is the number of fractional bits in the
+	// fixed-point filter weights. The weights can be negative and their sums
+	// can be a little more than one, so two bits are kept spare.
+	resamplePrecisionBits = 32 - 8 - 2
+)
+
+// resampleKernel holds the filter weights for resizing one axis of an image
+// from `inSize` to `outSize` pixels. Output pixel `i` is the weighted sum of
+// `counts[i]` input pixels starting at `starts[i]`. The weights follow the
+// Lanczos resampling of Pillow (`Image.resize()` with `Image.LANCZOS`, which
+// is what imagehash uses), including its fixed-point rounding, so that the
+// resized pixels are the same.
+type resampleKernel struct {
+	starts []int
+	counts []int
+
+	// weights holds `size` weights for every output pixel.
+	size    int
+	weights []int32
+}
+
+func newResampleKernel(inSize, outSize int) *resampleKernel {
+	scale := float64(inSize) / float64(outSize)
+
+	// When shrinking, the filter is stretched to cover every input pixel.
+	filterScale := math.Max(scale, 1.0)
+	support := lanczosSupport * filterScale
+
+	size := int(math.Ceil(support))*2 + 1
+
+	rk := &resampleKernel{
+		starts:  make([]int, outSize),
+		counts:  make([]int, outSize),
+		size:    size,
+		weights: make([]int32, outSize*size),
+	}
+
+	weights := make([]float64, size)
+
+	for i := 0; i < outSize; i++ {
+		center := (float64(i) + 0.5) * scale
+
+		start := int(center - support + 0.5)
+		if start < 0 {
+			start = 0
+		}
+
+		end := int(center + support + 0.5)
+		if end > inSize {
+			end = inSize
+		}
+
+		total := 0.0
+		for j := 0; j < end-start; j++ {
+			weights[j] = lanczos((float64(j+start) - center + 0.5) / filterScale)
+			total += weights[j]
+		}
+
+		for j := 0; j < end-start; j++ {
+			w := weights[j]
+			if total != 0.0 {
+				w /= total
+			}
+
+			// Round half away from zero.
+			if w < 0 {
+				rk.weights[i*size+j] = int32(-0.5 + w*(1<<resamplePrecisionBits))
+			} else {
+				rk.weights[i*size+j] = int32(0.5 + w*(1<<resamplePrecisionBits))
+			}
+		}
+
+		rk.starts[i] = start
+		rk.counts[i] = end - start
+	}
+
+	return rk
+}
+
+// apply returns output pixel `i`. Input pixel `j` is read from
+// `values[offset+j*stride]`, so that both rows and columns can be resampled.
+func (rk *resampleKernel) apply(i int, values []uint8, offset, stride int) uint8 {
+	weights := rk.weights[i*rk.size : i*rk.size+rk.counts[i]]
+	k := offset + rk.starts[i]*stride
+
+	sum := int32(1 << (resamplePrecisionBits - 1))
+	for _, w := range weights {
+		sum += int32(values[k]) * w
+		k += stride
+	}
+
+	v := sum >> resamplePrecisionBits
+	if v < 0 {
+		return 0
+	} else if v > 255 {
+		return 255
+	}
+
+	return uint8(v)
+}
+
+// resizeLuma converts the image to luma and resizes it to `columns` by `rows`
+// pixels, the same as Pillow's
+// `image.convert("L").resize((columns, rows), Image.LANCZOS)`. The pixels are
+// returned row by row. The context's error is returned if it is canceled
+// before all of the rows have been read.
+func resizeLuma(ctx context.Context, pr *pixelReader, columns, rows int) (pixels []uint8, err error) {
+	width, height := pr.size()
+
+	horizontal := newResampleKernel(width, columns)
+	vertical := newResampleKernel(height, rows)
+
+	// Only the rows that contribute to the output have to be read. As with
+	// Pillow, an axis that doesn't change size isn't resampled at all.
+
+	first := vertical.starts[0]
+	last := vertical.starts[rows-1] + vertical.counts[rows-1]
+
+	row := make([]uint8, width)
+	resized := make([]uint8, (last-first)*columns)
+
+	for y := first; y < last; y++ {
+		if (y-first)%cancellationCheckRows == 0 {
+			if err := ctx.Err(); err != nil {
+				return nil, err
+			}
+		}
+
+		pr.readLumaRow(y, row)
+
+		out := resized[(y-first)*columns : (y-first+1)*columns]
+		if width == columns {
+			copy(out, row)
+			continue
+		}
+
+		for x := range out {
+			out[x] = horizontal.apply(x, row, 0, 1)
+		}
+	}
+
+	if height == rows {
+		return resized, nil
+	}
+
+	pixels = make([]uint8, columns*rows)
+	for y := 0; y < rows; y++ {
+		for x := 0; x < columns; x++ {
+			pixels[y*columns+x] = vertical.apply(y, resized, x-first*columns, columns)
+		}
+	}
+
+	return pixels, nil
+}
+
+// lanczos is the Lanczos filter with a support of three.
+func lanczos(x float64) float64 {
+	if x < -lanczosSupport || x >= lanczosSupport {
+		return 0.0
+	}
+
+	return sinc(x) * sinc(x/lanczosSupport)
+}
+
+func sinc(x float64) float64 {
+	if x == 0.0 {
+		return 1.0
+	}
+
+	x *= math.Pi
+
+	return math.Sin(x) / x
+}
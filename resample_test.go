@@ -0,0 +1,93 @@
+package blockhash
+
+import (
+	"context"
+	"image"
+	"image/color"
+	"testing"
+
+	"github.com/dsoprea/go-logging"
+)
+
+func TestNewResampleKernel(t *testing.T) {
+	for _, sizes := range [][2]int{{1000, 32}, {33, 32}, {32, 32}, {20, 32}, {7, 3}} {
+		inSize, outSize := sizes[0], sizes[1]
+
+		rk := newResampleKernel(inSize, outSize)
+
+		for i := 0; i < outSize; i++ {
+			if rk.starts[i] < 0 || rk.starts[i]+rk.counts[i] > inSize || rk.counts[i] > rk.size {
+				t.Fatalf("(%d) -> (%d): bounds of (%d) not correct: (%d) (%d)", inSize, outSize, i, rk.starts[i], rk.counts[i])
+			}
+
+			// The weights are normalized, so they add up to one (give or take
+			// the rounding of each one).
+			total := int32(0)
+			for _, w := range rk.weights[i*rk.size : i*rk.size+rk.counts[i]] {
+				total += w
+			}
+
+			if total < 1<<resamplePrecisionBits-int32(rk.counts[i]) || total > 1<<resamplePrecisionBits+int32(rk.counts[i]) {
+				t.Fatalf("(%d) -> (%d): weights of (%d) not normalized: (%d)", inSize, outSize, i, total)
+			}
+		}
+	}
+}
+
+func TestResizeLuma__Constant(t *testing.T) {
+	i := image.NewGray(image.Rect(0, 0, 101, 57))
+	for y := 0; y < 57; y++ {
+		for x := 0; x < 101; x++ {
+			i.SetGray(x, y, color.Gray{Y: 77})
+		}
+	}
+
+	// Shrink one axis and stretch the other.
+	pixels, err := resizeLuma(context.Background(), newPixelReader(i), 32, 64)
+	log.PanicIf(err)
+
+	if len(pixels) != 32*64 {
+		t.Fatalf("pixel count not correct: (%d)", len(pixels))
+	}
+
+	for j, v := range pixels {
+		if v != 77 {
+			t.Fatalf("pixel (%d) not correct: (%d)", j, v)
+		}
+	}
+}
+
+func TestResizeLuma__Reference(t *testing.T) {
+	f, i := getTestImage(testImagePng1Small)
+	defer f.Close()
+
+	pixels, err := resizeLuma(context.Background(), newPixelReader(i), 32, 32)
+	log.PanicIf(err)
+
+	// Calculated by a transcription of Pillow's `convert("L")` and
+	// `resize((32, 32), Image.LANCZOS)`.
+	firstRow := []uint8{199, 201, 208, 213, 220, 225, 229, 231}
+	lastRow := []uint8{33, 34, 38, 32, 20, 21, 29, 31}
+
+	for j, v := range firstRow {
+		if pixels[j] != v {
+			t.Fatalf("pixel (%d) of the first row not correct: (%d) != (%d)", j, pixels[j], v)
+		}
+	}
+
+	for j, v := range lastRow {
+		if pixels[32*32-8+j] != v {
+			t.Fatalf("pixel (%d) of the last row not correct: (%d) != (%d)", 24+j, pixels[32*32-8+j], v)
+		}
+	}
+}
+
+func TestResizeLuma__Canceled(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	_, err := resizeLuma(ctx, newPixelReader(image.NewGray(image.Rect(0, 0, 64, 64))), 32, 32)
+	if err != context.Canceled {
+		t.Fatalf("expected cancellation error: %v", err)
+	}
+}
@@ -0,0 +1,80 @@
+package blockhash
+
+import (
+	"database/sql/driver"
+	"encoding/json"
+)
+
+// Value stores the digest in its binary form (see MarshalBinary()), which
+// suits BYTEA and BLOB columns. The zero Digest is stored as NULL. Use
+// TextDigest for text columns.
+func (d Digest) Value() (value driver.Value, err error) {
+	if d.isZero() == true {
+		return nil, nil
+	}
+
+	return d.MarshalBinary()
+}
+
+// Scan loads a digest stored in either its binary or its text form, from a
+// BYTEA, BLOB, or text column. NULL produces the zero Digest.
+func (d *Digest) Scan(src interface{}) (err error) {
+	switch v := src.(type) {
+	case nil:
+		*d = Digest{}
+		return nil
+	case []byte:
+		// The text form always starts with a letter or a digit, so it can't be
+		// confused with the version byte of the binary form.
+		if len(v) > 0 && v[0] == digestEncodingVersion {
+			return d.UnmarshalBinary(v)
+		}
+
+		return d.UnmarshalText(v)
+	case string:
+		return d.UnmarshalText([]byte(v))
+	}
+
+	return ErrInvalidDigest
+}
+
+// TextDigest uses the text form of a digest (see MarshalText()) wherever it is
+// stored or sent: in text columns and as a JSON string. It scans and
+// unmarshals the same way as Digest.
+type TextDigest struct {
+	Digest
+}
+
+// Value stores the digest in its text form. The zero Digest is stored as NULL.
+func (td TextDigest) Value() (value driver.Value, err error) {
+	if td.isZero() == true {
+		return nil, nil
+	}
+
+	text, err := td.MarshalText()
+	if err != nil {
+		return nil, err
+	}
+
+	return string(text), nil
+}
+
+// MarshalJSON encodes the digest as a JSON string of its text form, such as
+// "phash:8x8:b593c0690001ffff". The zero Digest is encoded as null.
+func (td TextDigest) MarshalJSON() (data []byte, err error) {
+	if td.isZero() == true {
+		return []byte("null"), nil
+	}
+
+	text, err := td.MarshalText()
+	if err != nil {
+		return nil, err
+	}
+
+	return json.Marshal(string(text))
+}
+
+// isZero returns true for the zero Digest, which has no bits.
+func (d Digest) isZero() bool {
+	return d.columns == 0 && d.rows == 0 && len(d.words) == 0
+}
@@ -0,0 +1,255 @@
+package blockhash
+
+import (
+	"database/sql"
+	"database/sql/driver"
+	"errors"
+	"io"
+	"sync"
+	"testing"
+
+	"github.com/dsoprea/go-logging"
+)
+
+// Make sure that digests can be stored and loaded by database/sql.
+var (
+	_ driver.Valuer = Digest{}
+	_ sql.Scanner   = new(Digest)
+	_ driver.Valuer = TextDigest{}
+	_ sql.Scanner   = new(TextDigest)
+)
+
+const (
+	testSqlDriverName = "blockhash-stub"
+)
+
+// stubDriver is an in-memory stand-in for a database. It understands three
+// statements: "INSERT" (key, value), "SELECT" (key), and "SELECT_BYTES" (key),
+// which returns text values as bytes the way that some drivers do.
+type stubDriver struct {
+	values map[string]driver.Value
+	lock   sync.Mutex
+}
+
+func (sd *stubDriver) Open(name string) (driver.Conn, error) {
+	return &stubConn{driver: sd}, nil
+}
+
+type stubConn struct {
+	driver *stubDriver
+}
+
+func (sc *stubConn) Prepare(query string) (driver.Stmt, error) {
+	return &stubStmt{driver: sc.driver, query: query}, nil
+}
+
+func (sc *stubConn) Close() error {
+	return nil
+}
+
+func (sc *stubConn) Begin() (driver.Tx, error) {
+	return nil, errors.New("transactions not supported")
+}
+
+type stubStmt struct {
+	driver *stubDriver
+	query  string
+}
+
+func (ss *stubStmt) Close() error {
+	return nil
+}
+
+func (ss *stubStmt) NumInput() int {
+	return -1
+}
+
+func (ss *stubStmt) Exec(args []driver.Value) (driver.Result, error) {
+	if ss.query != "INSERT" {
+		return nil, errors.New("unsupported statement")
+	}
+
+	ss.driver.lock.Lock()
+	defer ss.driver.lock.Unlock()
+
+	value := args[1]
+	if b, ok := value.([]byte); ok == true {
+		value = append([]byte{}, b...)
+	}
+
+	ss.driver.values[args[0].(string)] = value
+
+	return driver.RowsAffected(1), nil
+}
+
+func (ss *stubStmt) Query(args []driver.Value) (driver.Rows, error) {
+	if ss.query != "SELECT" && ss.query != "SELECT_BYTES" {
+		return nil, errors.New("unsupported query")
+	}
+
+	ss.driver.lock.Lock()
+	defer ss.driver.lock.Unlock()
+
+	value := ss.driver.values[args[0].(string)]
+	if s, ok := value.(string); ok == true && ss.query == "SELECT_BYTES" {
+		value = []byte(s)
+	}
+
+	return &stubRows{value: value}, nil
+}
+
+type stubRows struct {
+	value driver.Value
+	done  bool
+}
+
+func (sr *stubRows) Columns() []string {
+	return []string{"digest"}
+}
+
+func (sr *stubRows) Close() error {
+	return nil
+}
+
+func (sr *stubRows) Next(dest []driver.Value) error {
+	if sr.done == true {
+		return io.EOF
+	}
+
+	dest[0] = sr.value
+	sr.done = true
+
+	return nil
+}
+
+var (
+	testSqlDriver = &stubDriver{
+		values: make(map[string]driver.Value),
+	}
+)
+
+func init() {
+	sql.Register(testSqlDriverName, testSqlDriver)
+}
+
+func getTestDb() *sql.DB {
+	db, err := sql.Open(testSqlDriverName, "")
+	log.PanicIf(err)
+
+	return db
+}
+
+func TestDigest_Value(t *testing.T) {
+	digest, err := ParseDigest("0123456789abcdef", 8)
+	log.PanicIf(err)
+
+	value, err := digest.Value()
+	log.PanicIf(err)
+
+	expected, err := digest.MarshalBinary()
+	log.PanicIf(err)
+
+	if string(value.([]byte)) != string(expected) {
+		t.Fatalf("value not correct: %x", value)
+	}
+
+	value, err = TextDigest{digest}.Value()
+	log.PanicIf(err)
+
+	if value.(string) != "8x8:0123456789abcdef" {
+		t.Fatalf("text value not correct: [%s]", value)
+	}
+
+	value, err = Digest{}.Value()
+	log.PanicIf(err)
+
+	if value != nil {
+		t.Fatalf("zero digest should be NULL: %v", value)
+	}
+}
+
+func TestDigest_Scan__Invalid(t *testing.T) {
+	var digest Digest
+
+	err := digest.Scan(int64(12))
+	if err != ErrInvalidDigest {
+		t.Fatalf("expected invalid-digest error: %v", err)
+	}
+
+	err = digest.Scan([]byte{0x01, 0x00})
+	if err != ErrInvalidDigest {
+		t.Fatalf("expected invalid-digest error: %v", err)
+	}
+
+	err = digest.Scan("8x8:xyz")
+	if err != ErrInvalidDigest {
+		t.Fatalf("expected invalid-digest error: %v", err)
+	}
+}
+
+func TestDigest_Scan__Database(t *testing.T) {
+	db := getTestDb()
+	defer db.Close()
+
+	for i, digest := range getTestDigests() {
+		binaryKey := "binary" + digest.String()
+		textKey := "text" + digest.String()
+
+		_, err := db.Exec("INSERT", binaryKey, digest)
+		log.PanicIf(err)
+
+		_, err = db.Exec("INSERT", textKey, TextDigest{digest})
+		log.PanicIf(err)
+
+		// Binary (BYTEA/BLOB) column.
+
+		var fromBinary Digest
+
+		err = db.QueryRow("SELECT", binaryKey).Scan(&fromBinary)
+		log.PanicIf(err)
+
+		if fromBinary.Equal(digest) != true || fromBinary.Algorithm() != digest.Algorithm() {
+			t.Fatalf("(%d) digest did not round-trip through binary column: [%s]", i, fromBinary)
+		}
+
+		// Text column, returned both as a string and as bytes.
+
+		for _, query := range []string{"SELECT", "SELECT_BYTES"} {
+			var fromText Digest
+
+			err = db.QueryRow(query, textKey).Scan(&fromText)
+			log.PanicIf(err)
+
+			if fromText.Equal(digest) != true || fromText.Algorithm() != digest.Algorithm() {
+				t.Fatalf("(%d) digest did not round-trip through text column (%s): [%s]", i, query, fromText)
+			}
+
+			var fromTextDigest TextDigest
+
+			err = db.QueryRow(query, textKey).Scan(&fromTextDigest)
+			log.PanicIf(err)
+
+			if fromTextDigest.Equal(digest) != true {
+				t.Fatalf("(%d) text digest did not round-trip (%s): [%s]", i, query, fromTextDigest)
+			}
+		}
+	}
+}
+
+func TestDigest_Scan__Null(t *testing.T) {
+	db := getTestDb()
+	defer db.Close()
+
+	_, err := db.Exec("INSERT", "null", Digest{})
+	log.PanicIf(err)
+
+	digest, err := ParseDigest("0123456789abcdef", 8)
+	log.PanicIf(err)
+
+	err = db.QueryRow("SELECT", "null").Scan(&digest)
+	log.PanicIf(err)
+
+	if digest.Len() != 0 || digest.Columns() != 0 {
+		t.Fatalf("NULL should produce the zero digest: [%s]", digest)
+	}
+}
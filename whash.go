@@ -0,0 +1,197 @@
+package blockhash
+
+import (
+	"context"
+	"errors"
+	"image"
+	"math"
+
+	"github.com/dsoprea/go-logging"
+)
+
+const (
+	// DefaultWHashHashbits is the wHash grid size used when none is given.
+	DefaultWHashHashbits = 8
+
+	// DefaultWHashLevel is the number of Haar decompositions used when none
+	// is given.
+	DefaultWHashLevel = 3
+
+	// maxWHashGridSize bounds the number of columns and rows of blocks that
+	// the image is reduced to, (columns * 2^level) and (rows * 2^level). The
+	// image has to be at least as large.
+	maxWHashGridSize = 1 << 16
+)
+
+var (
+	// ErrInvalidLevel indicates that the wavelet decomposition level is not
+	// positive or is too large for the grid size.
+	ErrInvalidLevel = errors.New("level must be positive and small enough for the grid")
+)
+
+// WHash calculates wavelet hashes (wHash). The image is reduced to a grid of
+// (columns * 2^level) by (rows * 2^level) blocks and decomposed `level` times with
+// the Haar wavelet. The remaining approximation (LL) coefficients are
+// compared against their median. Discarding the detail coefficients at every
+// level makes this more stable than blockhash under recompression and blur.
+type WHash struct {
+	columns int
+	rows    int
+	level   int
+	method  Method
+}
+
+// NewWHash returns a wHash hasher. The grid size defaults to
+// DefaultWHashHashbits and the level to DefaultWHashLevel. ErrInvalidLevel is
+// returned if either side of the grid would be larger than 65536 blocks once
+// it is scaled up by the level.
+func NewWHash(opts ...Option) (wh *WHash, err error) {
+	o := newOptions(DefaultWHashHashbits, opts)
+
+	err = o.validate()
+	if err != nil {
+		return nil, err
+	}
+
+	// Compare against the bound shifted down so that large levels can't
+	// overflow.
+	if o.level < 1 || o.columns > maxWHashGridSize>>uint(o.level) || o.rows > maxWHashGridSize>>uint(o.level) {
+		return nil, ErrInvalidLevel
+	}
+
+	wh = &WHash{
+		columns: o.columns,
+		rows:    o.rows,
+		level:   o.level,
+		method:  o.method,
+	}
+
+	return wh, nil
+}
+
+// Hash calculates the digest of the given image.
+func (wh *WHash) Hash(img image.Image) (digest Digest, err error) {
+	return wh.HashContext(context.Background(), img)
+}
+
+// HashContext calculates the digest of the given image. The context's error is
+// returned if it is canceled while the image is being read.
+func (wh *WHash) HashContext(ctx context.Context, img image.Image) (digest Digest, err error) {
+	defer func() {
+		if state := recover(); state != nil {
+			err = log.Wrap(state.(error))
+		}
+	}()
+
+	columns := wh.columns << uint(wh.level)
+	rows := wh.rows << uint(wh.level)
+
+	err = validateImage(img, columns, rows)
+	if err != nil {
+		return Digest{}, err
+	}
+
+	pr := newPixelReader(img)
+	bb := getBlockBuffers()
+	defer putBlockBuffers(bb)
+
+	blocks, err := getBlocksForMethod(ctx, pr, wh.method, columns, rows, bb)
+	if err != nil {
+		return Digest{}, err
+	}
+
+	bits := wHashBits(blocks, columns, rows, wh.columns, wh.rows, wh.level)
+
+	return newDigest(AlgorithmWHash, bits, wh.columns, wh.rows), nil
+}
+
+// wHashBits decomposes the (columns x rows) values and compares the
+// (hashColumns x hashRows) approximation coefficients to their median.
+//
+// The reference implementation also removes the very lowest approximation
+// coefficient before decomposing. That only shifts every remaining
+// approximation coefficient by the same amount, which doesn't change how they
+// compare to their median, so it is skipped here.
+func wHashBits(values []float64, columns, rows, hashColumns, hashRows, level int) []int {
+	coefficients := haar2d(values, columns, rows, level)
+
+	lowFrequencies := make([]float64, hashColumns*hashRows)
+	for y := 0; y < hashRows; y++ {
+		copy(lowFrequencies[y*hashColumns:(y+1)*hashColumns], coefficients[y*columns:y*columns+hashColumns])
+	}
+
+	m := median(lowFrequencies)
+
+	bits := make([]int, len(lowFrequencies))
+	for i, v := range lowFrequencies {
+		if v > m {
+			bits[i] = 1
+		}
+	}
+
+	return bits
+}
+
+// haar2d applies `level` steps of the orthonormal 2D Haar wavelet transform
+// to the (columns x rows) values, which are stored row by row. Each step
+// transforms the rows and then the columns of the current approximation,
+// which always occupies the top-left corner, leaving the detail coefficients
+// to its right and below it.
+func haar2d(values []float64, columns, rows, level int) []float64 {
+	transformed := make([]float64, len(values))
+	copy(transformed, values)
+
+	longest := columns
+	if rows > longest {
+		longest = rows
+	}
+
+	in := make([]float64, longest)
+	out := make([]float64, longest)
+
+	nx := columns
+	ny := rows
+	for i := 0; i < level; i++ {
+		// Transform each row.
+
+		for y := 0; y < ny; y++ {
+			copy(in[:nx], transformed[y*columns:y*columns+nx])
+			haar1d(in[:nx], out[:nx])
+			copy(transformed[y*columns:y*columns+nx], out[:nx])
+		}
+
+		// Transform each column.
+
+		for x := 0; x < nx; x++ {
+			for y := 0; y < ny; y++ {
+				in[y] = transformed[y*columns+x]
+			}
+
+			haar1d(in[:ny], out[:ny])
+
+			for y := 0; y < ny; y++ {
+				transformed[y*columns+x] = out[y]
+			}
+		}
+
+		nx /= 2
+		ny /= 2
+	}
+
+	return transformed
+}
+
+// haar1d applies one step of the orthonormal Haar transform. The
+// approximation coefficients are written to the first half of `out` and the
+// detail coefficients to the second half.
+func haar1d(in, out []float64) {
+	half := len(in) / 2
+
+	for i := 0; i < half; i++ {
+		a := in[i*2]
+		b := in[i*2+1]
+
+		out[i] = (a + b) / math.Sqrt2
+		out[half+i] = (a - b) / math.Sqrt2
+	}
+}
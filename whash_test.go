@@ -0,0 +1,195 @@
+package blockhash
+
+import (
+	"bytes"
+	"image"
+	"image/color"
+	"image/jpeg"
+	"math"
+	"testing"
+
+	"github.com/dsoprea/go-logging"
+)
+
+func TestHaar2d(t *testing.T) {
+	values := []float64{
+		1, 2,
+		3, 4,
+	}
+
+	actual := haar2d(values, 2, 2, 1)
+
+	expected := []float64{
+		5, -1,
+		-2, 0,
+	}
+
+	for i, v := range expected {
+		if math.Abs(actual[i]-v) > 1e-9 {
+			t.Fatalf("coefficient (%d) not correct: %v", i, actual)
+		}
+	}
+}
+
+func TestHaar2d__Approximation(t *testing.T) {
+	values := make([]float64, 8*8)
+	for i := range values {
+		values[i] = float64(i % 5)
+	}
+
+	actual := haar2d(values, 8, 8, 2)
+
+	// After two levels, each approximation coefficient is the sum of a 4x4
+	// region divided by 4 (the orthonormal scaling of (1 / 2) per level).
+	for y := 0; y < 2; y++ {
+		for x := 0; x < 2; x++ {
+			sum := 0.0
+			for iy := 0; iy < 4; iy++ {
+				for ix := 0; ix < 4; ix++ {
+					sum += values[(y*4+iy)*8+x*4+ix]
+				}
+			}
+
+			if math.Abs(actual[y*8+x]-sum/4.0) > 1e-9 {
+				t.Fatalf("approximation (%d, %d) not correct: (%f) != (%f)", x, y, actual[y*8+x], sum/4.0)
+			}
+		}
+	}
+}
+
+func TestHaar2d__NonSquare(t *testing.T) {
+	values := make([]float64, 8*4)
+	for i := range values {
+		values[i] = float64(i % 7)
+	}
+
+	actual := haar2d(values, 8, 4, 2)
+
+	// As with a square grid, each approximation coefficient is the sum of a
+	// 4x4 region divided by 4.
+	for x := 0; x < 2; x++ {
+		sum := 0.0
+		for iy := 0; iy < 4; iy++ {
+			for ix := 0; ix < 4; ix++ {
+				sum += values[iy*8+x*4+ix]
+			}
+		}
+
+		if math.Abs(actual[x]-sum/4.0) > 1e-9 {
+			t.Fatalf("approximation (%d) not correct: (%f) != (%f)", x, actual[x], sum/4.0)
+		}
+	}
+}
+
+func TestNewWHash(t *testing.T) {
+	wh, err := NewWHash()
+	log.PanicIf(err)
+
+	if wh.columns != DefaultWHashHashbits || wh.rows != DefaultWHashHashbits {
+		t.Fatalf("default hashbits not applied: (%d) (%d)", wh.columns, wh.rows)
+	} else if wh.level != DefaultWHashLevel {
+		t.Fatalf("default level not applied: (%d)", wh.level)
+	}
+
+	wh, err = NewWHash(WithHashbits(4), WithLevel(2))
+	log.PanicIf(err)
+
+	if wh.columns != 4 || wh.rows != 4 || wh.level != 2 {
+		t.Fatalf("options not applied: (%d) (%d) (%d)", wh.columns, wh.rows, wh.level)
+	}
+}
+
+func TestNewWHash__InvalidLevel(t *testing.T) {
+	_, err := NewWHash(WithLevel(0))
+	if err != ErrInvalidLevel {
+		t.Fatalf("expected invalid-level error: %v", err)
+	}
+
+	// Levels that would overflow the grid.
+	for _, level := range []int{61, 64, 1000} {
+		_, err := NewWHash(WithLevel(level))
+		if err != ErrInvalidLevel {
+			t.Fatalf("expected invalid-level error for level (%d): %v", level, err)
+		}
+	}
+}
+
+func TestNewWHash__LargestLevel(t *testing.T) {
+	// 8 * 2^13 is the largest grid.
+	_, err := NewWHash(WithLevel(13))
+	log.PanicIf(err)
+
+	_, err = NewWHash(WithLevel(14))
+	if err != ErrInvalidLevel {
+		t.Fatalf("expected invalid-level error: %v", err)
+	}
+
+	// The longer side of the grid sets the limit.
+	_, err = NewWHash(WithGridSize(4, 16), WithLevel(13))
+	if err != ErrInvalidLevel {
+		t.Fatalf("expected invalid-level error for the rows: %v", err)
+	}
+}
+
+func TestWHash_Hash(t *testing.T) {
+	// A white top-left and bottom-right quadrant on black.
+
+	i := image.NewGray(image.Rect(0, 0, 64, 64))
+	for y := 0; y < 64; y++ {
+		for x := 0; x < 64; x++ {
+			if (x < 32) == (y < 32) {
+				i.SetGray(x, y, color.Gray{Y: 255})
+			}
+		}
+	}
+
+	wh, err := NewWHash(WithHashbits(4), WithLevel(2))
+	log.PanicIf(err)
+
+	digest, err := wh.Hash(i)
+	log.PanicIf(err)
+
+	if digest.String() != "cc33" {
+		t.Fatalf("digest not correct: [%s]", digest)
+	}
+}
+
+func TestWHash_Hash__Recompressed(t *testing.T) {
+	f, i := getTestImage(testImagePng1Small)
+	defer f.Close()
+
+	b := new(bytes.Buffer)
+
+	err := jpeg.Encode(b, i, &jpeg.Options{Quality: 30})
+	log.PanicIf(err)
+
+	recompressed, err := jpeg.Decode(b)
+	log.PanicIf(err)
+
+	wh, err := NewWHash(WithLevel(2))
+	log.PanicIf(err)
+
+	original, err := wh.Hash(i)
+	log.PanicIf(err)
+
+	recompressedDigest, err := wh.Hash(recompressed)
+	log.PanicIf(err)
+
+	distance, err := original.Distance(recompressedDigest)
+	log.PanicIf(err)
+
+	if distance > 4 {
+		t.Fatalf("recompressed digest too far from original: (%d)", distance)
+	}
+}
+
+func TestWHash_Hash__ImageTooSmall(t *testing.T) {
+	wh, err := NewWHash()
+	log.PanicIf(err)
+
+	// 8 * 2^3
+	_, err = wh.Hash(image.NewGray(image.Rect(0, 0, 63, 100)))
+	if err != ErrImageTooSmall {
+		t.Fatalf("expected image-too-small error: %v", err)
+	}
+}